@@ -6,34 +6,58 @@ import (
 	"blocker/proto"
 	"blocker/util"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func main() {
-	go func() { makeNode(":5001", []string{}, true) }()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	validators := []*crypto.PrivateKey{crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()}
+	paramsFile, err := writeParams(validators)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(paramsFile)
+
+	nodes := []*node.Node{makeNode(":5001", []string{}, paramsFile, validators[0])}
 	time.Sleep(time.Second * 1)
-	go func() { makeNode(":5002", []string{":5001"}, false) }()
+	nodes = append(nodes, makeNode(":5002", []string{":5001"}, paramsFile, validators[1]))
 
 	time.Sleep(4 * time.Second)
-	go func() { makeNode(":6000", []string{":5002"}, false) }()
+	nodes = append(nodes, makeNode(":6000", []string{":5002"}, paramsFile, nil))
 
 	for {
-		time.Sleep(time.Millisecond * 200)
-		makeTransaction()
+		select {
+		case <-ctx.Done():
+			for _, n := range nodes {
+				if err := n.Stop(); err != nil {
+					fmt.Printf("unable to stop node: %s\n", err)
+				}
+			}
+			return
+		case <-time.After(time.Millisecond * 200):
+			makeTransaction()
+		}
 	}
 }
 
-func makeNode(listenAddr string, bootstrapNodes []string, validator bool) *node.Node {
+func makeNode(listenAddr string, bootstrapNodes []string, paramsFile string, key *crypto.PrivateKey) *node.Node {
 	cfg := node.ServerConfig{
 		Version:    "blocker-1",
 		ListenAddr: listenAddr,
-	}
-
-	if validator {
-		cfg.PrivateKey = crypto.GeneratePrivateKey()
+		ParamsFile: paramsFile,
+		PrivateKey: key,
 	}
 
 	n := node.NewNode(cfg)
@@ -41,6 +65,25 @@ func makeNode(listenAddr string, bootstrapNodes []string, validator bool) *node.
 	return n
 }
 
+// writeParams writes a params file taking turns between the validators.
+func writeParams(validators []*crypto.PrivateKey) (string, error) {
+	keys := make([]string, len(validators))
+	for i, v := range validators {
+		keys[i] = hex.EncodeToString(v.Public().Bytes())
+	}
+	data, err := json.Marshal(map[string]any{"validators": keys})
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "blocker-params-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return f.Name(), err
+}
+
 func makeTransaction() {
 	client, err := grpc.Dial(":5001", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -69,6 +112,6 @@ func makeTransaction() {
 
 	_, err = c.HandleTransaction(context.Background(), tx)
 	if err != nil {
-		panic(err)
+		fmt.Printf("transaction rejected: %s\n", status.Convert(err).Message())
 	}
 }
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"blocker/proto"
+	"blocker/types"
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// evidencePool holds double signing evidence, by offender, until a block
+// slashes the offender.
+type evidencePool struct {
+	lock     sync.Mutex
+	evidence map[string]*proto.Evidence
+}
+
+func newEvidencePool() *evidencePool {
+	return &evidencePool{evidence: make(map[string]*proto.Evidence)}
+}
+
+// add records e and returns false if there already is evidence against the
+// same offender.
+func (p *evidencePool) add(e *proto.Evidence) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	offender := hex.EncodeToString(e.First.PublicKey)
+	if _, ok := p.evidence[offender]; ok {
+		return false
+	}
+	p.evidence[offender] = e
+	return true
+}
+
+func (p *evidencePool) remove(e *proto.Evidence) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.evidence, hex.EncodeToString(e.First.PublicKey))
+}
+
+func (p *evidencePool) list() []*proto.Evidence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	list := make([]*proto.Evidence, 0, len(p.evidence))
+	for _, e := range p.evidence {
+		list = append(list, e)
+	}
+	return list
+}
+
+// HandleEvidence accepts evidence that a validator signed two blocks at the
+// same height. The evidence is relayed to the peers and the next block this
+// node produces slashes the offender.
+func (n *Node) HandleEvidence(ctx context.Context, e *proto.Evidence) (*proto.Ack, error) {
+	if _, err := n.chain.SlashTransaction(e); err != nil {
+		switch {
+		case errors.Is(err, types.ErrSlashed):
+			return &proto.Ack{}, nil
+		case errors.Is(err, types.ErrNoStake):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		default:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if !n.evidence.add(e) {
+		return &proto.Ack{}, nil
+	}
+	n.logger.Infof("[%s] received evidence of double signing by %s at height %d", n.ListenAddr, hex.EncodeToString(e.First.PublicKey), e.First.Header.Height)
+	go func() {
+		if err := n.broadcast(e); err != nil {
+			n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
+		}
+	}()
+	return &proto.Ack{}, nil
+}
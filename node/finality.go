@@ -0,0 +1,255 @@
+package node
+
+import (
+	"blocker/proto"
+	"blocker/types"
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Finality follows Tendermint's rounds on top of slot leader blocks. The
+// leader's block is the proposal and the round is its slot. Every validator
+// of the block's epoch prevotes for the proposal it connects on its tip.
+// Once validators with more than 2/3 of the stake prevoted for a block in a
+// round, each validator precommits to it and stays locked on it for that
+// height. Precommits of more than 2/3 of the stake in one round make the
+// block final.
+
+type voteKey struct {
+	typ    proto.VoteType
+	height int32
+	round  int64
+	hash   string
+}
+
+// votePool collects the votes of the validators and the votes this node
+// cast itself.
+type votePool struct {
+	lock sync.Mutex
+	// votes holds the stake of each voter, by vote.
+	votes map[voteKey]map[string]int64
+	// prevoted holds the rounds this node prevoted in, by height.
+	prevoted map[int32]map[int64]bool
+	// locked holds the block this node precommitted to, by height.
+	locked map[int32]string
+	// pending holds blocks with a precommit quorum that are not on the
+	// main chain yet.
+	pending map[string]bool
+}
+
+func newVotePool() *votePool {
+	return &votePool{
+		votes:    make(map[voteKey]map[string]int64),
+		prevoted: make(map[int32]map[int64]bool),
+		locked:   make(map[int32]string),
+		pending:  make(map[string]bool),
+	}
+}
+
+// add records v, cast by a validator with the given stake, and returns the
+// stake of all validators that cast the same vote. It returns false if v was
+// already recorded.
+func (p *votePool) add(v *proto.Vote, stake int64) (int64, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := voteKey{typ: v.Type, height: v.Height, round: v.Round, hash: hex.EncodeToString(v.BlockHash)}
+	voters, ok := p.votes[key]
+	if !ok {
+		voters = make(map[string]int64)
+		p.votes[key] = voters
+	}
+	voter := hex.EncodeToString(v.PublicKey)
+	_, seen := voters[voter]
+	if !seen {
+		voters[voter] = stake
+	}
+	var total int64
+	for _, s := range voters {
+		total += s
+	}
+	return total, !seen
+}
+
+// canPrevote reports whether this node may prevote for hash in the given
+// round and records the prevote if so. A node prevotes once per round and
+// only for the block it is locked on, if any.
+func (p *votePool) canPrevote(height int32, round int64, hash string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if locked, ok := p.locked[height]; ok && locked != hash {
+		return false
+	}
+	if p.prevoted[height][round] {
+		return false
+	}
+	if p.prevoted[height] == nil {
+		p.prevoted[height] = make(map[int64]bool)
+	}
+	p.prevoted[height][round] = true
+	return true
+}
+
+// canPrecommit reports whether this node may precommit to hash and locks it
+// on hash for the height if so.
+func (p *votePool) canPrecommit(height int32, hash string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.locked[height]; ok {
+		return false
+	}
+	p.locked[height] = hash
+	return true
+}
+
+// prune forgets everything at or below height.
+func (p *votePool) prune(height int32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key := range p.votes {
+		if key.height <= height {
+			delete(p.votes, key)
+		}
+	}
+	for h := range p.prevoted {
+		if h <= height {
+			delete(p.prevoted, h)
+		}
+	}
+	for h := range p.locked {
+		if h <= height {
+			delete(p.locked, h)
+		}
+	}
+}
+
+func (p *votePool) setPending(hash string, pending bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if pending {
+		p.pending[hash] = true
+	} else {
+		delete(p.pending, hash)
+	}
+}
+
+func (p *votePool) pendingBlocks() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	hashes := make([]string, 0, len(p.pending))
+	for hash := range p.pending {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// quorum returns the stake needed out of the total stake of a validator set,
+// which is more than 2/3 of it.
+func quorum(total int64) int64 {
+	return total*2/3 + 1
+}
+
+func (n *Node) HandleVote(ctx context.Context, v *proto.Vote) (*proto.Ack, error) {
+	if int(v.Height) <= n.chain.FinalizedHeight() {
+		return &proto.Ack{}, nil
+	}
+	set, err := n.chain.ValidatorSet(int(v.Height))
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "unable to check vote: %s", err)
+	}
+	if set.Open() {
+		return nil, status.Error(codes.FailedPrecondition, "no validator set configured")
+	}
+	if set.Stake(v.PublicKey) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "vote from unknown validator %s", hex.EncodeToString(v.PublicKey))
+	}
+	if !types.VerifyVote(v) {
+		return nil, status.Error(codes.InvalidArgument, "invalid vote signature")
+	}
+	n.addVote(v, set)
+	return &proto.Ack{}, nil
+}
+
+// addVote records v, cast by a validator of set, relays it to the peers if
+// it is new and acts on the quorum it completes.
+func (n *Node) addVote(v *proto.Vote, set *types.ValidatorSet) {
+	stake := set.Stake(v.PublicKey)
+	total, added := n.votes.add(v, stake)
+	if !added {
+		return
+	}
+	go func() {
+		if err := n.broadcast(v); err != nil {
+			n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
+		}
+	}()
+
+	// Only the vote that reaches the quorum acts on it.
+	if q := quorum(set.TotalStake()); total < q || total-stake >= q {
+		return
+	}
+	hash := hex.EncodeToString(v.BlockHash)
+	switch v.Type {
+	case proto.VoteType_PREVOTE:
+		if n.isValidator(set) && n.votes.canPrecommit(v.Height, hash) {
+			n.castVote(set, proto.VoteType_PRECOMMIT, v.Height, v.Round, v.BlockHash)
+		}
+	case proto.VoteType_PRECOMMIT:
+		n.finalize(hash)
+	}
+}
+
+func (n *Node) castVote(set *types.ValidatorSet, typ proto.VoteType, height int32, round int64, hash []byte) {
+	v := &proto.Vote{
+		Type:      typ,
+		Height:    height,
+		Round:     round,
+		BlockHash: hash,
+	}
+	types.SignVote(n.PrivateKey, v)
+	n.addVote(v, set)
+}
+
+// prevote votes for b if this node is a validator of b's epoch and b is its
+// tip.
+func (n *Node) prevote(b *proto.Block) {
+	set, err := n.chain.ValidatorSet(int(b.Header.Height))
+	if err != nil || !n.isValidator(set) || int(b.Header.Height) != n.chain.Height() {
+		return
+	}
+	hash := types.HashBlock(b)
+	round := n.chain.Params().Slot(b.Header.Timestamp)
+	if n.votes.canPrevote(b.Header.Height, round, hex.EncodeToString(hash)) {
+		n.castVote(set, proto.VoteType_PREVOTE, b.Header.Height, round, hash)
+	}
+}
+
+// finalize marks the block final once it is on the main chain. Until then
+// it is kept pending and retried whenever a block is connected.
+func (n *Node) finalize(hash string) {
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		return
+	}
+	if err := n.chain.Finalize(h); err != nil {
+		n.votes.setPending(hash, true)
+		return
+	}
+	n.votes.setPending(hash, false)
+	height := n.chain.FinalizedHeight()
+	n.votes.prune(int32(height))
+	n.logger.Infof("[%s] finalized block %s, finalized height is %d", n.ListenAddr, hash, height)
+}
+
+// isValidator reports whether this node takes part in the finality votes
+// of set.
+func (n *Node) isValidator(set *types.ValidatorSet) bool {
+	return n.PrivateKey != nil && set.Stake(n.PrivateKey.Public().Bytes()) > 0
+}
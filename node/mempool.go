@@ -0,0 +1,710 @@
+package node
+
+import (
+	"blocker/proto"
+	"blocker/types"
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protodelim"
+	pb "google.golang.org/protobuf/proto"
+)
+
+const (
+	// defaultMinRelayFeeRate is the lowest fee per 1000 bytes a transaction
+	// has to pay to be accepted into the mempool.
+	defaultMinRelayFeeRate = 1
+	defaultMaxMempoolTxs   = 5000
+	defaultMaxMempoolBytes = 1 << 24
+	defaultMempoolExpiry   = time.Hour
+
+	// maxAncestors limits how many unconfirmed ancestors a pooled
+	// transaction may have.
+	maxAncestors = 25
+)
+
+var (
+	ErrTxExists           = errors.New("transaction already in mempool")
+	ErrInvalidTx          = errors.New("invalid transaction")
+	ErrMissingInput       = errors.New("input not found")
+	ErrSpentInput         = errors.New("input already spent")
+	ErrDoubleSpend        = errors.New("input spent by another mempool transaction")
+	ErrInsufficientInputs = errors.New("outputs exceed inputs")
+	ErrLowFee             = errors.New("fee rate below minimum relay fee rate")
+	ErrMempoolFull        = errors.New("mempool full")
+	ErrTooManyAncestors   = errors.New("too many unconfirmed ancestors")
+)
+
+type mempoolTx struct {
+	tx    *proto.Transaction
+	hash  string
+	fee   int64
+	size  int
+	added time.Time
+	// parents are the hashes of the pooled transactions whose outputs tx
+	// spends. Parents that get confirmed leave the pool but stay listed.
+	parents []string
+}
+
+// feeRate returns the fee per 1000 bytes.
+func (t *mempoolTx) feeRate() int64 {
+	return t.fee * 1000 / int64(t.size)
+}
+
+// higherFeeRate reports whether a pays more per byte than b, comparing
+// without rounding. Ties go to the transaction that arrived first.
+func higherFeeRate(a, b *mempoolTx) bool {
+	x, y := a.fee*int64(b.size), b.fee*int64(a.size)
+	if x != y {
+		return x > y
+	}
+	return a.added.Before(b.added)
+}
+
+func outpointKey(txHash string, index int) string {
+	return fmt.Sprintf("%s_%d", txHash, index)
+}
+
+// outpoint identifies the output an input spends.
+func outpoint(input *proto.TxInput) string {
+	return outpointKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex))
+}
+
+// MempoolConfig bounds the mempool. Zero fields use the defaults.
+type MempoolConfig struct {
+	// MinFeeRate is the lowest fee per 1000 bytes the mempool accepts.
+	MinFeeRate int64
+	// MaxTxs and MaxBytes limit the number and combined size of pooled
+	// transactions. When full, the lowest fee rate entries are evicted.
+	MaxTxs   int
+	MaxBytes int
+	// MaxAge is how long a transaction may wait for a block before it
+	// expires.
+	MaxAge time.Duration
+}
+
+// MempoolStats reports the size of the mempool and how many transactions
+// left it without being mined.
+type MempoolStats struct {
+	Txs      int
+	Bytes    int
+	Evicted  int
+	Expired  int
+	Replaced int
+}
+
+type Mempool struct {
+	lock   sync.RWMutex
+	chain  *types.Chain
+	cfg    MempoolConfig
+	logger *zap.SugaredLogger
+	txx    map[string]*mempoolTx
+	// spends maps every outpoint spent by a pooled transaction to the hash
+	// of that transaction.
+	spends   map[string]string
+	size     int
+	evicted  int
+	expired  int
+	replaced int
+}
+
+func NewMempool(chain *types.Chain, cfg MempoolConfig, logger *zap.SugaredLogger) *Mempool {
+	if cfg.MinFeeRate == 0 {
+		cfg.MinFeeRate = defaultMinRelayFeeRate
+	}
+	if cfg.MaxTxs == 0 {
+		cfg.MaxTxs = defaultMaxMempoolTxs
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = defaultMaxMempoolBytes
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = defaultMempoolExpiry
+	}
+	return &Mempool{
+		chain:  chain,
+		cfg:    cfg,
+		logger: logger,
+		txx:    make(map[string]*mempoolTx),
+		spends: make(map[string]string),
+	}
+}
+
+func (m *Mempool) Has(tx *proto.Transaction) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.txx[hex.EncodeToString(types.HashTransaction(tx))]
+	return ok
+}
+
+// Add admits tx to the pool if it is correctly signed, spends only unspent
+// outputs on the chain or outputs of pooled transactions, and pays at least
+// the minimum relay fee rate.
+//
+// A transaction spending an output that a pooled transaction already spends
+// replaces the conflicting transactions and their descendants, but only if
+// it pays a strictly higher fee than all of them together. If the pool is
+// full, entries with a lower fee rate than tx are evicted to make room. The
+// returned error wraps one of the Err* sentinels.
+func (m *Mempool) Add(tx *proto.Transaction) error {
+	if err := checkTx(tx); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+
+	hash := hex.EncodeToString(types.HashTransaction(tx))
+	if _, ok := m.txx[hash]; ok {
+		return ErrTxExists
+	}
+	entry, ancestors, conflicts, err := m.newEntry(tx, hash, time.Now())
+	if err != nil {
+		return err
+	}
+	fee := entry.fee
+	replaced := m.withDescendants(conflicts)
+	var replacedFee int64
+	for _, r := range replaced {
+		replacedFee += r.fee
+	}
+	if len(replaced) > 0 && fee <= replacedFee {
+		return fmt.Errorf("%w: replacing %d transactions requires a fee above %d, got %d", ErrDoubleSpend, len(replaced), replacedFee, fee)
+	}
+	for h := range ancestors {
+		if _, ok := replaced[h]; ok {
+			return fmt.Errorf("%w: transaction spends an output of %s, which it replaces", ErrDoubleSpend, h)
+		}
+	}
+	victims, err := m.makeRoom(entry, ancestors, replaced)
+	if err != nil {
+		return err
+	}
+	for _, r := range replaced {
+		m.remove(r)
+		m.replaced++
+		m.logger.Infof("replaced transaction %s with %s", r.hash, hash)
+	}
+	for _, victim := range victims {
+		m.remove(victim)
+		m.evicted++
+		m.logger.Infof("evicted transaction %s with fee rate %d to admit %s", victim.hash, victim.feeRate(), hash)
+	}
+
+	m.insert(entry)
+	return nil
+}
+
+// checkTx runs the checks on tx that do not depend on the chain or the pool.
+func checkTx(tx *proto.Transaction) error {
+	if types.IsCoinbase(tx) {
+		return fmt.Errorf("%w: transaction has no inputs", ErrInvalidTx)
+	}
+	if !types.VerifyTransaction(tx) {
+		return fmt.Errorf("%w: bad signature", ErrInvalidTx)
+	}
+	if err := types.CheckTxType(tx); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidTx, err)
+	}
+	if tx.Type == proto.TxType_SLASH {
+		return fmt.Errorf("%w: slash transactions are built from evidence", ErrInvalidTx)
+	}
+	return nil
+}
+
+// newEntry checks tx against the chain and the pool and returns its pool
+// entry together with its pooled ancestors and the pooled transactions it
+// conflicts with.
+func (m *Mempool) newEntry(tx *proto.Transaction, hash string, added time.Time) (*mempoolTx, map[string]*mempoolTx, []*mempoolTx, error) {
+	fee, parents, conflicts, err := m.checkInputs(tx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	entry := &mempoolTx{
+		tx:      tx,
+		hash:    hash,
+		fee:     fee,
+		size:    pb.Size(tx),
+		added:   added,
+		parents: parents,
+	}
+	ancestors := m.ancestors(entry)
+	if len(ancestors) > maxAncestors {
+		return nil, nil, nil, fmt.Errorf("%w: %d > %d", ErrTooManyAncestors, len(ancestors), maxAncestors)
+	}
+	if entry.feeRate() < m.cfg.MinFeeRate {
+		return nil, nil, nil, fmt.Errorf("%w: %d < %d", ErrLowFee, entry.feeRate(), m.cfg.MinFeeRate)
+	}
+	return entry, ancestors, conflicts, nil
+}
+
+func (m *Mempool) insert(entry *mempoolTx) {
+	m.txx[entry.hash] = entry
+	m.size += entry.size
+	for _, input := range entry.tx.Inputs {
+		m.spends[outpoint(input)] = entry.hash
+	}
+}
+
+// makeRoom returns the entries that have to be evicted for entry to fit in
+// the pool once the replaced entries are gone. Entries are evicted lowest fee
+// rate first, together with their descendants. It fails if that would evict
+// an ancestor of entry or an entry paying at least as much per byte.
+func (m *Mempool) makeRoom(entry *mempoolTx, ancestors, replaced map[string]*mempoolTx) ([]*mempoolTx, error) {
+	if entry.size > m.cfg.MaxBytes {
+		return nil, fmt.Errorf("%w: transaction of %d bytes exceeds the limit of %d", ErrMempoolFull, entry.size, m.cfg.MaxBytes)
+	}
+	txs, size := len(m.txx)+1, m.size+entry.size
+	for _, r := range replaced {
+		txs--
+		size -= r.size
+	}
+	if txs <= m.cfg.MaxTxs && size <= m.cfg.MaxBytes {
+		return nil, nil
+	}
+
+	entries := m.sorted()
+	evicted := make(map[string]bool)
+	victims := []*mempoolTx{}
+	for i := len(entries) - 1; i >= 0 && (txs > m.cfg.MaxTxs || size > m.cfg.MaxBytes); i-- {
+		if _, ok := replaced[entries[i].hash]; ok || evicted[entries[i].hash] {
+			continue
+		}
+		if !higherFeeRate(entry, entries[i]) {
+			return nil, fmt.Errorf("%w: fee rate %d is too low", ErrMempoolFull, entry.feeRate())
+		}
+		for _, victim := range m.withDescendants([]*mempoolTx{entries[i]}) {
+			if _, ok := ancestors[victim.hash]; ok {
+				return nil, fmt.Errorf("%w: admitting the transaction would evict its ancestor %s", ErrMempoolFull, victim.hash)
+			}
+			if _, ok := replaced[victim.hash]; ok || evicted[victim.hash] {
+				continue
+			}
+			evicted[victim.hash] = true
+			victims = append(victims, victim)
+			txs--
+			size -= victim.size
+		}
+	}
+	return victims, nil
+}
+
+// expire drops entries older than the configured maximum age together with
+// their descendants.
+func (m *Mempool) expire() {
+	for _, entry := range m.txx {
+		if _, ok := m.txx[entry.hash]; !ok || time.Since(entry.added) <= m.cfg.MaxAge {
+			continue
+		}
+		for _, e := range m.withDescendants([]*mempoolTx{entry}) {
+			m.remove(e)
+			m.expired++
+			m.logger.Infof("expired transaction %s after %s", e.hash, m.cfg.MaxAge)
+		}
+	}
+}
+
+// checkInputs verifies that the outputs of tx pay positive amounts and its
+// inputs against the chain and the outputs of pooled transactions. It
+// returns the fee tx pays, the pooled parents it spends from and the pooled
+// transactions it conflicts with.
+func (m *Mempool) checkInputs(tx *proto.Transaction) (int64, []string, []*mempoolTx, error) {
+	if err := types.CheckOutputs(tx); err != nil {
+		return 0, nil, nil, fmt.Errorf("%w: %w", ErrInvalidTx, err)
+	}
+	var (
+		fee       int64
+		parents   []string
+		conflicts []*mempoolTx
+	)
+	params := m.chain.Params()
+	height := m.chain.Height() + 1
+	seen := make(map[string]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		key := outpoint(input)
+		if seen[key] {
+			return 0, nil, nil, fmt.Errorf("%w: %s is spent twice", ErrDoubleSpend, key)
+		}
+		seen[key] = true
+		if other, ok := m.spends[key]; ok {
+			conflicts = append(conflicts, m.txx[other])
+		}
+
+		if parent, ok := m.txx[hex.EncodeToString(input.PrevTxHash)]; ok {
+			if int(input.PrevOutIndex) >= len(parent.tx.Outputs) {
+				return 0, nil, nil, fmt.Errorf("%w: %s", ErrMissingInput, key)
+			}
+			if !slices.Contains(parents, parent.hash) {
+				parents = append(parents, parent.hash)
+			}
+			utxo := params.OutputUTXO(parent.tx, parent.hash, int(input.PrevOutIndex), height)
+			if err := types.CheckSpend(tx, input, utxo, height); err != nil {
+				return 0, nil, nil, fmt.Errorf("%w: %w", ErrInvalidTx, err)
+			}
+			fee += utxo.Amount
+			continue
+		}
+		utxo, err := m.chain.GetUTXO(input.PrevTxHash, int(input.PrevOutIndex))
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("%w: %s", ErrMissingInput, key)
+		}
+		if utxo.Spent {
+			return 0, nil, nil, fmt.Errorf("%w: %s", ErrSpentInput, key)
+		}
+		if err := types.CheckSpend(tx, input, utxo, height); err != nil {
+			return 0, nil, nil, fmt.Errorf("%w: %w", ErrInvalidTx, err)
+		}
+		fee += utxo.Amount
+	}
+	for _, output := range tx.Outputs {
+		fee -= output.Amount
+	}
+	if fee < 0 {
+		return 0, nil, nil, fmt.Errorf("%w by %d", ErrInsufficientInputs, -fee)
+	}
+	return fee, parents, conflicts, nil
+}
+
+// ancestors returns the pooled transactions entry spends from, directly or
+// through other pooled transactions, keyed by hash.
+func (m *Mempool) ancestors(entry *mempoolTx) map[string]*mempoolTx {
+	found := make(map[string]*mempoolTx)
+	queue := append([]string{}, entry.parents...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		parent, ok := m.txx[hash]
+		if !ok {
+			continue
+		}
+		if _, ok := found[hash]; ok {
+			continue
+		}
+		found[hash] = parent
+		queue = append(queue, parent.parents...)
+	}
+	return found
+}
+
+// withDescendants returns entries together with every pooled transaction
+// that spends their outputs, directly or through other pooled transactions,
+// keyed by hash.
+func (m *Mempool) withDescendants(entries []*mempoolTx) map[string]*mempoolTx {
+	found := make(map[string]*mempoolTx)
+	queue := append([]*mempoolTx{}, entries...)
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+		if _, ok := found[entry.hash]; ok {
+			continue
+		}
+		found[entry.hash] = entry
+		for i := range entry.tx.Outputs {
+			if child, ok := m.spends[outpointKey(entry.hash, i)]; ok {
+				queue = append(queue, m.txx[child])
+			}
+		}
+	}
+	return found
+}
+
+// Remove deletes the transactions with the given hashes from the pool and
+// returns how many were present.
+func (m *Mempool) Remove(hashes []string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	n := 0
+	for _, hash := range hashes {
+		if entry, ok := m.txx[hash]; ok {
+			m.remove(entry)
+			n++
+		}
+	}
+	return n
+}
+
+// RemoveConfirmed drops the transactions of a block connected to the chain
+// from the pool, together with the pooled transactions that spend the same
+// outputs and their descendants. It returns how many conflicting
+// transactions were dropped.
+func (m *Mempool) RemoveConfirmed(b *proto.Block) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n := 0
+	for _, tx := range b.Transactions {
+		if entry, ok := m.txx[hex.EncodeToString(types.HashTransaction(tx))]; ok {
+			m.remove(entry)
+			continue
+		}
+		for _, input := range tx.Inputs {
+			other, ok := m.spends[outpoint(input)]
+			if !ok {
+				continue
+			}
+			for _, entry := range m.withDescendants([]*mempoolTx{m.txx[other]}) {
+				m.remove(entry)
+				n++
+				m.logger.Infof("dropped transaction %s, which conflicts with block %s", entry.hash, hex.EncodeToString(types.HashBlock(b)))
+			}
+		}
+	}
+	return n
+}
+
+// Revalidate re-checks the pool after blocks were disconnected from the
+// chain. The transactions of the disconnected blocks, txx, are admitted
+// first so they win over pooled transactions conflicting with them. Pooled
+// transactions that are no longer valid are dropped.
+func (m *Mempool) Revalidate(txx []*proto.Transaction) {
+	now := time.Now()
+	candidates := make([]*mempoolTx, 0, len(txx))
+	for _, tx := range txx {
+		// Slash transactions are rebuilt from their evidence by the node.
+		if !types.IsCoinbase(tx) && tx.Type != proto.TxType_SLASH {
+			candidates = append(candidates, &mempoolTx{tx: tx, hash: hex.EncodeToString(types.HashTransaction(tx)), added: now})
+		}
+	}
+	m.revalidate(candidates)
+}
+
+// revalidate admits candidates ahead of the pooled transactions, keeping
+// the arrival time of each.
+func (m *Mempool) revalidate(candidates []*mempoolTx) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	pooled := make([]*mempoolTx, 0, len(m.txx))
+	for _, entry := range m.txx {
+		pooled = append(pooled, entry)
+	}
+	sort.Slice(pooled, func(i, j int) bool {
+		return pooled[i].added.Before(pooled[j].added)
+	})
+	candidates = append(candidates, pooled...)
+
+	m.txx = make(map[string]*mempoolTx)
+	m.spends = make(map[string]string)
+	m.size = 0
+	// A child may come before its parent, so transactions with missing
+	// inputs are retried as long as others get admitted.
+	for len(candidates) > 0 {
+		retry := []*mempoolTx{}
+		for _, c := range candidates {
+			err := m.readmit(c)
+			if errors.Is(err, ErrMissingInput) {
+				retry = append(retry, c)
+			} else if err != nil && !errors.Is(err, ErrTxExists) {
+				m.logger.Infof("dropped transaction %s: %s", c.hash, err)
+			}
+		}
+		if len(retry) == len(candidates) {
+			for _, c := range retry {
+				m.logger.Infof("dropped transaction %s: inputs not found", c.hash)
+			}
+			break
+		}
+		candidates = retry
+	}
+}
+
+// readmit adds c to the pool without replacing or evicting other entries.
+func (m *Mempool) readmit(c *mempoolTx) error {
+	if _, ok := m.txx[c.hash]; ok {
+		return ErrTxExists
+	}
+	if err := checkTx(c.tx); err != nil {
+		return err
+	}
+	entry, _, conflicts, err := m.newEntry(c.tx, c.hash, c.added)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: conflicts with %s", ErrDoubleSpend, conflicts[0].hash)
+	}
+	if len(m.txx)+1 > m.cfg.MaxTxs || m.size+entry.size > m.cfg.MaxBytes {
+		return ErrMempoolFull
+	}
+	m.insert(entry)
+	return nil
+}
+
+func (m *Mempool) remove(entry *mempoolTx) {
+	delete(m.txx, entry.hash)
+	m.size -= entry.size
+	for _, input := range entry.tx.Inputs {
+		if key := outpoint(input); m.spends[key] == entry.hash {
+			delete(m.spends, key)
+		}
+	}
+}
+
+// Save writes the pooled transactions to path in the order they arrived,
+// each preceded by its arrival time so expiry survives a restart. The file
+// is replaced atomically.
+func (m *Mempool) Save(path string) error {
+	m.lock.RLock()
+	entries := make([]*mempoolTx, 0, len(m.txx))
+	for _, entry := range m.txx {
+		entries = append(entries, entry)
+	}
+	m.lock.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].added.Before(entries[j].added)
+	})
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		if err := binary.Write(w, binary.BigEndian, entry.added.UnixNano()); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := protodelim.MarshalTo(w, entry.tx); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load admits the transactions saved to path by Save, revalidating each
+// against the chain, and returns how many are in the pool afterwards.
+// Transactions keep their original arrival time, so those older than MaxAge
+// are expired. A missing file is not an error.
+func (m *Mempool) Load(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	entries := []*mempoolTx{}
+	r := bufio.NewReader(f)
+	for {
+		var added int64
+		err := binary.Read(r, binary.BigEndian, &added)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		tx := &proto.Transaction{}
+		if err == nil {
+			err = protodelim.UnmarshalFrom(r, tx)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("corrupt mempool file %s: %w", path, err)
+		}
+		entries = append(entries, &mempoolTx{tx: tx, hash: hex.EncodeToString(types.HashTransaction(tx)), added: time.Unix(0, added)})
+	}
+	m.revalidate(entries)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+	return len(m.txx), nil
+}
+
+// ByFeeRate returns the pooled transactions in the order a block should
+// include them: packages of a transaction and its unconfirmed ancestors by
+// combined fee per byte, highest first, with every transaction after its
+// parents.
+func (m *Mempool) ByFeeRate() []*proto.Transaction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire()
+
+	packages := make(map[string]*mempoolTx, len(m.txx))
+	entries := make([]*mempoolTx, 0, len(m.txx))
+	for hash, entry := range m.txx {
+		pkg := &mempoolTx{hash: hash, fee: entry.fee, size: entry.size, added: entry.added}
+		for _, ancestor := range m.ancestors(entry) {
+			pkg.fee += ancestor.fee
+			pkg.size += ancestor.size
+		}
+		packages[hash] = pkg
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return higherFeeRate(packages[entries[i].hash], packages[entries[j].hash])
+	})
+
+	txx := make([]*proto.Transaction, 0, len(entries))
+	added := make(map[string]bool, len(entries))
+	var add func(entry *mempoolTx)
+	add = func(entry *mempoolTx) {
+		if added[entry.hash] {
+			return
+		}
+		added[entry.hash] = true
+		for _, hash := range entry.parents {
+			if parent, ok := m.txx[hash]; ok {
+				add(parent)
+			}
+		}
+		txx = append(txx, entry.tx)
+	}
+	for _, entry := range entries {
+		add(entry)
+	}
+	return txx
+}
+
+// sorted returns the pooled entries ordered by fee per byte, highest first.
+func (m *Mempool) sorted() []*mempoolTx {
+	entries := make([]*mempoolTx, 0, len(m.txx))
+	for _, entry := range m.txx {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return higherFeeRate(entries[i], entries[j])
+	})
+	return entries
+}
+
+func (m *Mempool) Stats() MempoolStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return MempoolStats{
+		Txs:      len(m.txx),
+		Bytes:    m.size,
+		Evicted:  m.evicted,
+		Expired:  m.expired,
+		Replaced: m.replaced,
+	}
+}
+
+func (m *Mempool) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.txx)
+}
@@ -0,0 +1,332 @@
+package node
+
+import (
+	"blocker/proto"
+	"blocker/types"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// spend returns a transaction signed with the genesis key that spends
+// output index of prev and pays fee to the block producer.
+func spend(prev *proto.Transaction, index uint32, fee int64) *proto.Transaction {
+	privKey := types.Factory{}.CreateGenesisPrivateKey()
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{
+				PrevTxHash:   types.HashTransaction(prev),
+				PublicKey:    privKey.Public().Bytes(),
+				PrevOutIndex: index,
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Amount:    prev.Outputs[index].Amount - fee,
+				ToAddress: privKey.Public().Address().Bytes(),
+			},
+		},
+	}
+	tx.Inputs[0].Signature = types.SignTransaction(privKey, tx).Bytes()
+	return tx
+}
+
+func spendGenesis(t *testing.T, chain *types.Chain, fee int64) *proto.Transaction {
+	genesis, err := chain.GetBlockByHeight(0)
+	require.Nil(t, err)
+	return spend(genesis.Transactions[0], 0, fee)
+}
+
+// splitGenesis adds a block to chain that splits the genesis output into n
+// outputs owned by the genesis key and returns the splitting transaction.
+func splitGenesis(t *testing.T, chain *types.Chain, n int) *proto.Transaction {
+	genesis, err := chain.GetBlockByHeight(0)
+	require.Nil(t, err)
+
+	tx := spendGenesis(t, chain, 0)
+	total := tx.Outputs[0].Amount
+	tx.Outputs = nil
+	for i := 0; i < n; i++ {
+		amount := total / int64(n)
+		if i == n-1 {
+			amount += total % int64(n)
+		}
+		tx.Outputs = append(tx.Outputs, &proto.TxOutput{
+			Amount:    amount,
+			ToAddress: types.Factory{}.CreateGenesisPrivateKey().Public().Address().Bytes(),
+		})
+	}
+	privKey := types.Factory{}.CreateGenesisPrivateKey()
+	tx.Inputs[0].Signature = nil
+	tx.Inputs[0].Signature = types.SignTransaction(privKey, tx).Bytes()
+
+	block := &proto.Block{
+		Header: &proto.Header{
+			Version:      1,
+			Height:       1,
+			PreviousHash: types.HashBlock(genesis),
+			Timestamp:    time.Now().UnixNano(),
+		},
+		Transactions: []*proto.Transaction{
+			types.NewCoinbaseTransaction(types.Factory{}.CreateAddress(), 1, chain.Params().BlockReward),
+			tx,
+		},
+	}
+	types.SignBlock(privKey, block)
+	require.Nil(t, chain.AddBlock(block))
+	return tx
+}
+
+func TestMempoolAdd(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{MinFeeRate: 10}, zap.NewNop().Sugar())
+
+	assert.ErrorIs(t, mempool.Add(spendGenesis(t, chain, 0)), ErrLowFee)
+	assert.ErrorIs(t, mempool.Add(spendGenesis(t, chain, -1)), ErrInsufficientInputs)
+
+	tx := spendGenesis(t, chain, 5)
+	require.Nil(t, mempool.Add(tx))
+	assert.ErrorIs(t, mempool.Add(tx), ErrTxExists)
+	assert.True(t, mempool.Has(tx))
+	assert.Equal(t, 1, mempool.Len())
+}
+
+func TestMempoolByFeeRate(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	split := splitGenesis(t, chain, 3)
+	low := spend(split, 0, 1)
+	high := spend(split, 1, 50)
+	mid := spend(split, 2, 10)
+	for _, tx := range []*proto.Transaction{low, high, mid} {
+		require.Nil(t, mempool.Add(tx))
+	}
+
+	assert.Equal(t, []*proto.Transaction{high, mid, low}, mempool.ByFeeRate())
+
+	hash := hex.EncodeToString(types.HashTransaction(mid))
+	assert.Equal(t, 1, mempool.Remove([]string{hash, hash}))
+	assert.Equal(t, []*proto.Transaction{high, low}, mempool.ByFeeRate())
+}
+
+func TestMempoolRejectsInvalidTransactions(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	unsigned := spendGenesis(t, chain, 5)
+	unsigned.Inputs[0].Signature = nil
+	assert.ErrorIs(t, mempool.Add(unsigned), ErrInvalidTx)
+
+	privKey := types.Factory{}.CreateGenesisPrivateKey()
+	missing := spendGenesis(t, chain, 5)
+	missing.Inputs[0].PrevOutIndex = 1
+	missing.Inputs[0].Signature = nil
+	missing.Inputs[0].Signature = types.SignTransaction(privKey, missing).Bytes()
+	assert.ErrorIs(t, mempool.Add(missing), ErrMissingInput)
+
+	minting := spendGenesis(t, chain, 5)
+	minting.Outputs = append(minting.Outputs, &proto.TxOutput{Amount: -1000, ToAddress: minting.Outputs[0].ToAddress})
+	minting.Outputs[0].Amount += 1000
+	minting.Inputs[0].Signature = nil
+	minting.Inputs[0].Signature = types.SignTransaction(privKey, minting).Bytes()
+	assert.ErrorIs(t, mempool.Add(minting), types.ErrBadAmount)
+
+	require.Nil(t, mempool.Add(spendGenesis(t, chain, 5)))
+	conflict := spendGenesis(t, chain, 5)
+	conflict.Outputs[0].ToAddress = types.Factory{}.CreateAddress()
+	conflict.Inputs[0].Signature = nil
+	conflict.Inputs[0].Signature = types.SignTransaction(privKey, conflict).Bytes()
+	assert.ErrorIs(t, mempool.Add(conflict), ErrDoubleSpend)
+	assert.Equal(t, 1, mempool.Len())
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	stuck := spendGenesis(t, chain, 5)
+	require.Nil(t, mempool.Add(stuck))
+
+	assert.ErrorIs(t, mempool.Add(spendGenesis(t, chain, 4)), ErrDoubleSpend)
+	assert.True(t, mempool.Has(stuck))
+
+	bumped := spendGenesis(t, chain, 6)
+	require.Nil(t, mempool.Add(bumped))
+	assert.False(t, mempool.Has(stuck))
+	assert.Equal(t, []*proto.Transaction{bumped}, mempool.ByFeeRate())
+	assert.Equal(t, 1, mempool.Stats().Replaced)
+
+	assert.ErrorIs(t, mempool.Add(stuck), ErrDoubleSpend)
+	assert.Nil(t, mempool.Add(spendGenesis(t, chain, 7)))
+	assert.Equal(t, 1, mempool.Len())
+}
+
+func TestMempoolRemoveReleasesInputs(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	tx := spendGenesis(t, chain, 5)
+	require.Nil(t, mempool.Add(tx))
+	mempool.Remove([]string{hex.EncodeToString(types.HashTransaction(tx))})
+
+	assert.Nil(t, mempool.Add(spendGenesis(t, chain, 6)))
+}
+
+func TestMempoolRejectsSpentInput(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+	splitGenesis(t, chain, 2)
+
+	assert.ErrorIs(t, mempool.Add(spendGenesis(t, chain, 5)), ErrSpentInput)
+}
+
+func TestMempoolEvictsLowestFeeRate(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{MaxTxs: 2}, zap.NewNop().Sugar())
+
+	split := splitGenesis(t, chain, 4)
+	low := spend(split, 0, 5)
+	mid := spend(split, 1, 10)
+	require.Nil(t, mempool.Add(low))
+	require.Nil(t, mempool.Add(mid))
+
+	assert.ErrorIs(t, mempool.Add(spend(split, 2, 5)), ErrMempoolFull)
+
+	high := spend(split, 3, 50)
+	require.Nil(t, mempool.Add(high))
+	assert.Equal(t, []*proto.Transaction{high, mid}, mempool.ByFeeRate())
+	assert.Equal(t, 1, mempool.Stats().Evicted)
+}
+
+func TestMempoolMaxBytes(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	split := splitGenesis(t, chain, 2)
+	tx := spend(split, 0, 5)
+	mempool := NewMempool(chain, MempoolConfig{MaxBytes: pb.Size(tx) + 10}, zap.NewNop().Sugar())
+
+	require.Nil(t, mempool.Add(tx))
+	require.Nil(t, mempool.Add(spend(split, 1, 50)))
+	assert.Equal(t, 1, mempool.Len())
+	assert.False(t, mempool.Has(tx))
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{MaxAge: time.Minute}, zap.NewNop().Sugar())
+
+	tx := spendGenesis(t, chain, 5)
+	require.Nil(t, mempool.Add(tx))
+	mempool.txx[hex.EncodeToString(types.HashTransaction(tx))].added = time.Now().Add(-time.Hour)
+
+	assert.Empty(t, mempool.ByFeeRate())
+	assert.Equal(t, MempoolStats{Expired: 1}, mempool.Stats())
+	assert.Nil(t, mempool.Add(spendGenesis(t, chain, 6)))
+}
+
+func TestMempoolChainedTransactions(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	split := splitGenesis(t, chain, 2)
+	parent := spend(split, 0, 1)
+	child := spend(parent, 0, 100)
+	other := spend(split, 1, 20)
+
+	assert.ErrorIs(t, mempool.Add(child), ErrMissingInput)
+	require.Nil(t, mempool.Add(parent))
+	require.Nil(t, mempool.Add(child))
+	require.Nil(t, mempool.Add(other))
+
+	assert.Equal(t, []*proto.Transaction{parent, child, other}, mempool.ByFeeRate())
+}
+
+func TestMempoolReplacingParentEvictsChild(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	parent := spendGenesis(t, chain, 5)
+	child := spend(parent, 0, 5)
+	require.Nil(t, mempool.Add(parent))
+	require.Nil(t, mempool.Add(child))
+
+	assert.ErrorIs(t, mempool.Add(spendGenesis(t, chain, 10)), ErrDoubleSpend)
+
+	bumped := spendGenesis(t, chain, 11)
+	require.Nil(t, mempool.Add(bumped))
+	assert.Equal(t, []*proto.Transaction{bumped}, mempool.ByFeeRate())
+	assert.Equal(t, 2, mempool.Stats().Replaced)
+}
+
+func TestMempoolExpiryEvictsDescendants(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{MaxAge: time.Minute}, zap.NewNop().Sugar())
+
+	parent := spendGenesis(t, chain, 5)
+	require.Nil(t, mempool.Add(parent))
+	require.Nil(t, mempool.Add(spend(parent, 0, 5)))
+	mempool.txx[hex.EncodeToString(types.HashTransaction(parent))].added = time.Now().Add(-time.Hour)
+
+	assert.Empty(t, mempool.ByFeeRate())
+	assert.Equal(t, 2, mempool.Stats().Expired)
+}
+
+func TestMempoolRevalidateDropsInvalidTransactions(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+
+	parent := spendGenesis(t, chain, 5)
+	child := spend(parent, 0, 5)
+	require.Nil(t, mempool.Add(parent))
+	require.Nil(t, mempool.Add(child))
+
+	// A block spending the genesis output differently invalidates both.
+	splitGenesis(t, chain, 2)
+	mempool.Revalidate([]*proto.Transaction{parent})
+	assert.Equal(t, 0, mempool.Len())
+}
+
+func TestMempoolSaveLoad(t *testing.T) {
+	chain := types.NewChain(types.NewMemoryBlockStore(), types.NewMemoryTXStore())
+	mempool := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+	path := filepath.Join(t.TempDir(), mempoolFileName)
+
+	n, err := mempool.Load(path)
+	require.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	parent := spendGenesis(t, chain, 5)
+	child := spend(parent, 0, 5)
+	require.Nil(t, mempool.Add(parent))
+	require.Nil(t, mempool.Add(child))
+	require.Nil(t, mempool.Save(path))
+
+	restored := NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+	n, err = restored.Load(path)
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []*proto.Transaction{parent, child}, restored.ByFeeRate())
+
+	// Transactions keep their arrival time, so a restart does not postpone
+	// their expiry.
+	restored.txx[hex.EncodeToString(types.HashTransaction(parent))].added = time.Now().Add(-time.Hour)
+	require.Nil(t, restored.Save(path))
+	restored = NewMempool(chain, MempoolConfig{MaxAge: time.Minute}, zap.NewNop().Sugar())
+	n, err = restored.Load(path)
+	require.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, MempoolStats{Expired: 2}, restored.Stats())
+
+	splitGenesis(t, chain, 2)
+	restored = NewMempool(chain, MempoolConfig{}, zap.NewNop().Sugar())
+	n, err = restored.Load(path)
+	require.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,50 @@
+package node
+
+import (
+	"blocker/proto"
+	"blocker/types"
+	"bytes"
+	"encoding/hex"
+	"time"
+)
+
+// mineBatch is the number of nonces tried between checks for a new tip or a
+// shutdown.
+const mineBatch = 1 << 12
+
+// minerLoop mines blocks on top of the current tip until the node stops.
+// The block template is rebuilt when another block extends the tip and
+// every SlotDuration to pick up new transactions.
+func (n *Node) minerLoop() {
+	n.logger.Infof("[%s] starting miner with key %s", n.ListenAddr, hex.EncodeToString(n.PrivateKey.Public().Bytes()))
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		default:
+		}
+		block := n.createBlock(n.mempool.ByFeeRate())
+		if n.mine(block) {
+			n.submitBlock(block)
+		}
+	}
+}
+
+// mine searches a nonce for block and signs it once found. It gives up and
+// returns false when the template goes stale or the node stops.
+func (n *Node) mine(block *proto.Block) bool {
+	deadline := time.Now().Add(n.chain.Params().SlotDuration)
+	for !types.Mine(block.Header, mineBatch) {
+		select {
+		case <-n.quit:
+			return false
+		default:
+		}
+		if time.Now().After(deadline) || !bytes.Equal(types.HashHeader(n.chain.Tip()), block.Header.PreviousHash) {
+			return false
+		}
+	}
+	types.SignBlock(n.PrivateKey, block)
+	return true
+}
@@ -4,77 +4,73 @@ import (
 	"blocker/crypto"
 	"blocker/proto"
 	"blocker/types"
+	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"maps"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	peer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	pb "google.golang.org/protobuf/proto"
 )
 
-const blockTime = time.Second * 5
+// listenAddrKey is the metadata key under which a node sends its listen
+// address along with gossiped messages.
+const listenAddrKey = "listen-addr"
 
-type Mempool struct {
-	lock sync.RWMutex
-	txx  map[string]*proto.Transaction
-}
+const (
+	syncRetries    = 3
+	syncRetryDelay = time.Second
 
-func NewMempool() *Mempool {
-	return &Mempool{
-		txx: make(map[string]*proto.Transaction),
-	}
-}
+	maxParentRequests = 100
 
-func (m *Mempool) Has(tx *proto.Transaction) bool {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	tx, ok := m.txx[hex.EncodeToString(types.HashTransaction(tx))]
-	return ok
-}
+	// mempoolFileName is the file in DataDir the mempool is saved to on Stop.
+	mempoolFileName = "mempool.dat"
 
-func (m *Mempool) Add(tx *proto.Transaction) bool {
-	if m.Has(tx) {
-		return false
-	}
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.txx[hex.EncodeToString(types.HashTransaction(tx))] = tx
-	return true
-}
-
-func (m *Mempool) Clear() []*proto.Transaction {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	txx := make([]*proto.Transaction, len(m.txx))
-	i := 0
-	for k, v := range m.txx {
-		delete(m.txx, k)
-		txx[i] = v
-		i++
-	}
-
-	return txx
-}
+	defaultMaxBlockSize = 1 << 20
+)
 
-func (m *Mempool) Len() int {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	return len(m.txx)
-}
+var (
+	errBlockRejected = errors.New("block rejected")
+	errNoHeader      = errors.New("block has no header")
+)
 
 type ServerConfig struct {
 	Version    string
 	ListenAddr string
 	PrivateKey *crypto.PrivateKey
+	BlockStore types.BlockStorer
+	TXStore    types.TXStorer
+	UTXOStore  types.UTXOStorer
+	// DataDir, when set, keeps the chain in a file store in that directory
+	// instead of the stores above.
+	DataDir string
+	// ParamsFile, when set, is a JSON file with the consensus parameters of
+	// the network, such as the validator set. See types.LoadParams.
+	ParamsFile string
+	// BlockReward is minted by every block on top of its fees. Zero uses
+	// the default reward.
+	BlockReward int64
+	// Mempool bounds the pool of transactions waiting for a block.
+	Mempool MempoolConfig
+	// MaxBlockSize limits the combined size in bytes of the transactions a
+	// validator puts into a block. Zero uses the default size.
+	MaxBlockSize int
 }
 
 type Node struct {
@@ -84,7 +80,17 @@ type Node struct {
 	peerLock sync.RWMutex
 	peers    map[proto.NodeClient]*proto.Version
 
-	mempool *Mempool
+	mempool  *Mempool
+	chain    *types.Chain
+	votes    *votePool
+	evidence *evidencePool
+	store    *types.FileStore
+	syncing  atomic.Bool
+
+	serverLock sync.Mutex
+	server     *grpc.Server
+	quit       chan struct{}
+	producer   sync.WaitGroup
 
 	proto.UnimplementedNodeServer
 }
@@ -103,12 +109,66 @@ func NewLogger() *zap.SugaredLogger {
 }
 
 func NewNode(cfg ServerConfig) *Node {
-	return &Node{
+	var store *types.FileStore
+	if cfg.DataDir != "" {
+		var err error
+		store, err = types.OpenFileStore(cfg.DataDir)
+		if err != nil {
+			panic(err)
+		}
+		cfg.BlockStore = store.BlockStore()
+		cfg.TXStore = store.TXStore()
+		cfg.UTXOStore = store.UTXOStore()
+	}
+	if cfg.BlockStore == nil {
+		cfg.BlockStore = types.NewMemoryBlockStore()
+	}
+	if cfg.TXStore == nil {
+		cfg.TXStore = types.NewMemoryTXStore()
+	}
+	if cfg.UTXOStore == nil {
+		cfg.UTXOStore = types.NewMemoryUTXOStore()
+	}
+	chain, err := types.OpenChain(cfg.BlockStore, cfg.TXStore, cfg.UTXOStore)
+	if err != nil {
+		panic(err)
+	}
+	params := chain.Params()
+	if cfg.ParamsFile != "" {
+		if params, err = types.LoadParams(cfg.ParamsFile); err != nil {
+			panic(err)
+		}
+	}
+	if cfg.BlockReward > 0 {
+		params.BlockReward = cfg.BlockReward
+	}
+	chain.SetParams(params)
+	if cfg.MaxBlockSize == 0 {
+		cfg.MaxBlockSize = defaultMaxBlockSize
+	}
+	logger := NewLogger()
+	n := &Node{
 		ServerConfig: cfg,
 		peers:        make(map[proto.NodeClient]*proto.Version),
-		logger:       NewLogger(),
-		mempool:      NewMempool(),
+		logger:       logger,
+		mempool:      NewMempool(chain, cfg.Mempool, logger.With("node", cfg.ListenAddr)),
+		chain:        chain,
+		votes:        newVotePool(),
+		evidence:     newEvidencePool(),
+		store:        store,
+		quit:         make(chan struct{}),
 	}
+	n.chain.OnBlockConnected(n.handleBlockConnected)
+	n.chain.OnReorg(n.handleReorg)
+	if cfg.DataDir != "" {
+		restored, err := n.mempool.Load(filepath.Join(cfg.DataDir, mempoolFileName))
+		if err != nil {
+			n.logger.Errorf("[%s] unable to load mempool: %s", cfg.ListenAddr, err)
+		} else if restored > 0 {
+			n.logger.Infof("[%s] restored %d mempool transactions", cfg.ListenAddr, restored)
+		}
+	}
+	return n
 }
 
 func (n *Node) Start(listenAddr string, bootstrapNodes []string) error {
@@ -126,27 +186,121 @@ func (n *Node) Start(listenAddr string, bootstrapNodes []string) error {
 		go n.bootstrapNetwork(bootstrapNodes)
 	}
 
+	n.serverLock.Lock()
+	n.server = grpcSerer
 	if n.PrivateKey != nil {
-		go n.validatorLoop()
+		n.producer.Add(1)
+		go func() {
+			defer n.producer.Done()
+			if n.chain.Params().ProofOfWork {
+				n.minerLoop()
+			} else {
+				n.validatorLoop()
+			}
+		}()
 	}
+	n.serverLock.Unlock()
 
 	return grpcSerer.Serve(ln)
 }
 
+// Stop shuts the node down gracefully. The gRPC server finishes in-flight
+// requests, the validator or miner loop returns, and with a DataDir the
+// mempool is saved so it can be restored on the next start.
+func (n *Node) Stop() error {
+	n.serverLock.Lock()
+	select {
+	case <-n.quit:
+		n.serverLock.Unlock()
+		return nil
+	default:
+		close(n.quit)
+	}
+	server := n.server
+	n.serverLock.Unlock()
+
+	if server != nil {
+		server.GracefulStop()
+	}
+	n.producer.Wait()
+	if n.store == nil {
+		return nil
+	}
+	if err := n.mempool.Save(filepath.Join(n.DataDir, mempoolFileName)); err != nil {
+		n.store.Close()
+		return err
+	}
+	n.logger.Infof("[%s] saved %d mempool transactions", n.ListenAddr, n.mempool.Len())
+	return n.store.Close()
+}
+
 func (n *Node) HandleTransaction(ctx context.Context, tx *proto.Transaction) (*proto.Ack, error) {
 	peer, _ := peer.FromContext(ctx)
-	if n.mempool.Add(tx) {
-		n.logger.Infof("[%s] received new transaction from %s with hash %s", n.ListenAddr, peer.Addr, hex.EncodeToString(types.HashTransaction(tx)))
-		go func() {
-			if err := n.broadcast(tx); err != nil {
-				n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
+	hash := hex.EncodeToString(types.HashTransaction(tx))
+	if err := n.mempool.Add(tx); err != nil {
+		if errors.Is(err, ErrTxExists) {
+			return &proto.Ack{}, nil
+		}
+		n.logger.Debugf("[%s] rejected transaction %s from %s: %s", n.ListenAddr, hash, peer.Addr, err)
+		return nil, rejectionStatus(err)
+	}
+	n.logger.Infof("[%s] received new transaction from %s with hash %s", n.ListenAddr, peer.Addr, hash)
+	go func() {
+		if err := n.broadcast(tx); err != nil {
+			n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
 
+		}
+	}()
+	return &proto.Ack{}, nil
+}
+
+func (n *Node) HandleBlock(ctx context.Context, b *proto.Block) (*proto.Ack, error) {
+	peer, _ := peer.FromContext(ctx)
+	if b.Header == nil {
+		return nil, status.Error(codes.InvalidArgument, errNoHeader.Error())
+	}
+	hash := types.HashBlock(b)
+	if n.chain.HasBlock(hash) {
+		return &proto.Ack{}, nil
+	}
+	if err := n.chain.AddBlock(b); err != nil {
+		if errors.Is(err, types.ErrOrphanBlock) {
+			n.logger.Debugf("[%s] received orphan block %s from %s at height %d", n.ListenAddr, hex.EncodeToString(hash), peer.Addr, b.Header.Height)
+			if c := n.peerFromContext(ctx); c != nil {
+				go n.requestParent(c, b)
 			}
-		}()
+			return &proto.Ack{}, nil
+		}
+		n.logger.Errorf("[%s] rejected block %s from %s: %s", n.ListenAddr, hex.EncodeToString(hash), peer.Addr, err)
+		return nil, blockRejectionStatus(err)
 	}
+	n.logger.Infof("[%s] received new block from %s with hash %s at height %d", n.ListenAddr, peer.Addr, hex.EncodeToString(hash), b.Header.Height)
+
+	go func() {
+		if err := n.broadcast(b); err != nil {
+			n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
+		}
+	}()
 	return &proto.Ack{}, nil
 }
 
+func (n *Node) GetBlocks(r *proto.BlockRange, stream proto.Node_GetBlocksServer) error {
+	to := int(r.To)
+	if height := n.chain.Height(); to > height {
+		to = height
+	}
+	for height := int(r.From); height <= to; height++ {
+		b, err := n.chain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (n *Node) Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error) {
 	n.logger.Infof("[%s] *** Hanshake from %s", n.ListenAddr, v.ListenAddr)
 	p, _ := peer.FromContext(ctx)
@@ -158,32 +312,370 @@ func (n *Node) Handshake(ctx context.Context, v *proto.Version) (*proto.Version,
 
 	n.addPeer(c, v)
 
+	if int(v.Height) > n.chain.Height() {
+		go n.syncChain()
+	}
+
 	return n.getVersion(), nil
 }
 
+// validatorLoop produces a block at the start of every slot this node is
+// the leader of. The leader comes from the validator set of the epoch of the
+// next block, so the node starts and stops producing as its stake changes.
+// Slots whose leader is offline pass without a block and the next leader
+// builds on the last block it has.
 func (n *Node) validatorLoop() {
-	n.logger.Infof("[%s} starting validator loop with key %s", n.ListenAddr, hex.EncodeToString(n.PrivateKey.Public().Bytes()))
-	ticker := time.NewTicker(blockTime)
+	params := n.chain.Params()
+	pubKey := n.PrivateKey.Public().Bytes()
+	n.logger.Infof("[%s] starting validator loop with key %s", n.ListenAddr, hex.EncodeToString(pubKey))
+
 	for {
-		<-ticker.C
+		next := (params.Slot(time.Now().UnixNano()) + 1) * int64(params.SlotDuration)
+		select {
+		case <-time.After(time.Until(time.Unix(0, next))):
+		case <-n.quit:
+			return
+		}
+
+		height := n.chain.Height()
+		set, err := n.chain.ValidatorSet(height + 1)
+		if err != nil {
+			n.logger.Errorf("[%s] unable to get the validator set: %s", n.ListenAddr, err)
+			continue
+		}
+		slot := params.Slot(time.Now().UnixNano())
+		if !set.IsValidator(pubKey) {
+			continue
+		}
+		if leader := set.Leader(slot); leader != nil && !bytes.Equal(leader, pubKey) {
+			continue
+		}
+		if missed := slot - params.Slot(n.chain.Tip().Timestamp) - 1; set.Len() > 0 && height > 0 && missed > 0 {
+			n.logger.Debugf("[%s] %d slots passed without a block since height %d", n.ListenAddr, missed, height)
+		}
+		n.produceBlock()
+	}
+}
 
-		txx := n.mempool.Clear()
+func (n *Node) produceBlock() {
+	txx := n.mempool.ByFeeRate()
 
-		n.logger.Debugf("[%s} creating a new block with %d transactions", n.ListenAddr, len(txx))
+	n.logger.Debugf("[%s] creating a new block from %d pending transactions", n.ListenAddr, len(txx))
+
+	n.submitBlock(n.createBlock(txx))
+}
+
+// submitBlock adds a block this node produced to its chain and sends it to
+// the peers.
+func (n *Node) submitBlock(block *proto.Block) {
+	if err := n.chain.AddBlock(block); err != nil {
+		n.logger.Errorf("[%s] unable to add block at height %d: %s", n.ListenAddr, block.Header.Height, err)
+		return
+	}
+	n.logger.Infof("[%s] added block %s at height %d with %d transactions", n.ListenAddr, hex.EncodeToString(types.HashBlock(block)), block.Header.Height, len(block.Transactions))
+
+	go func() {
+		if err := n.broadcast(block); err != nil {
+			n.logger.Errorf("[%s] broadcast error: %s", n.ListenAddr, err)
+		}
+	}()
+}
+
+// createBlock builds a block on top of the current tip and signs it with the
+// node's private key. The block starts with a coinbase paying the block
+// reward and the fees to the validator, followed by a slash transaction for
+// each pending double signing evidence and as many of txx as fit in
+// MaxBlockSize, taken in order. A transaction may spend outputs of one
+// included before it. Transactions that fail validation against the chain
+// are dropped from the mempool.
+func (n *Node) createBlock(txx []*proto.Transaction) *proto.Block {
+	tip := n.chain.Tip()
+	valid, fees := n.blockTransactions(txx)
+
+	reward := n.chain.Params().BlockReward + fees
+	coinbase := types.NewCoinbaseTransaction(n.PrivateKey.Public().Address().Bytes(), tip.Height+1, reward)
+	valid = append([]*proto.Transaction{coinbase}, valid...)
+
+	block := &proto.Block{
+		Header: &proto.Header{
+			Version:      1,
+			Height:       tip.Height + 1,
+			PreviousHash: types.HashHeader(tip),
+			Timestamp:    time.Now().UnixNano(),
+			Bits:         n.chain.NextBits(),
+		},
+		Transactions: valid,
 	}
+	types.SignBlock(n.PrivateKey, block)
+	return block
+}
+
+// blockTransactions selects the transactions of the block after the tip
+// from the pending evidence and txx, checking each against the block view
+// the chain validates the block with, and returns them with the fees they
+// pay.
+func (n *Node) blockTransactions(txx []*proto.Transaction) ([]*proto.Transaction, int64) {
+	view, err := n.chain.NewBlockView()
+	if err != nil {
+		n.logger.Errorf("[%s] unable to assemble block transactions: %s", n.ListenAddr, err)
+		return nil, 0
+	}
+	var (
+		fees int64
+		size int
+	)
+	valid := []*proto.Transaction{}
+	for _, e := range n.evidence.list() {
+		tx, err := n.chain.SlashTransaction(e)
+		if err == nil {
+			_, err = view.Add(tx)
+		}
+		if err != nil {
+			n.logger.Debugf("[%s] dropping evidence against %s: %s", n.ListenAddr, hex.EncodeToString(e.First.PublicKey), err)
+			n.evidence.remove(e)
+			continue
+		}
+		valid = append(valid, tx)
+	}
+	for _, tx := range txx {
+		txSize := pb.Size(tx)
+		if size+txSize > n.MaxBlockSize {
+			continue
+		}
+		hash := hex.EncodeToString(types.HashTransaction(tx))
+		fee, err := view.Add(tx)
+		if errors.Is(err, types.ErrMissingInput) {
+			// The parent was left out of this block.
+			continue
+		}
+		if err != nil {
+			n.logger.Debugf("[%s] dropping transaction %s: %s", n.ListenAddr, hash, err)
+			n.mempool.Remove([]string{hash})
+			continue
+		}
+		size += txSize
+		fees += fee
+		valid = append(valid, tx)
+	}
+	return valid, fees
+}
+
+func (n *Node) handleBlockConnected(b *proto.Block) {
+	if dropped := n.mempool.RemoveConfirmed(b); dropped > 0 {
+		n.logger.Infof("[%s] dropped %d mempool transactions conflicting with block at height %d", n.ListenAddr, dropped, b.Header.Height)
+	}
+	for _, hash := range n.votes.pendingBlocks() {
+		n.finalize(hash)
+	}
+	n.prevote(b)
+}
+
+func (n *Node) handleReorg(r *types.Reorg) {
+	n.logger.Infof("[%s] reorganized chain at height %d: disconnected %d blocks, connected %d blocks", n.ListenAddr, r.ForkHeight, len(r.Disconnected), len(r.Connected))
+	txx := []*proto.Transaction{}
+	for i := len(r.Disconnected) - 1; i >= 0; i-- {
+		txx = append(txx, r.Disconnected[i].Transactions...)
+	}
+	// The offenders slashed by disconnected blocks are slashed again by the
+	// next block this node produces.
+	for _, tx := range txx {
+		if tx.Type == proto.TxType_SLASH && tx.Evidence != nil {
+			n.evidence.add(tx.Evidence)
+		}
+	}
+	n.mempool.Revalidate(txx)
+}
+
+// syncChain downloads missing blocks from the tallest known peer until no
+// peer reports a higher chain than ours. Only one sync runs at a time.
+func (n *Node) syncChain() {
+	if !n.syncing.CompareAndSwap(false, true) {
+		return
+	}
+	defer n.syncing.Store(false)
+
+	for {
+		c, v := n.tallestPeer()
+		height := n.chain.Height()
+		if c == nil || int(v.Height) <= height {
+			return
+		}
+		n.logger.Infof("[%s] syncing blocks %d to %d from %s", n.ListenAddr, height+1, v.Height, v.ListenAddr)
+		if err := n.syncWithPeer(c, v); err != nil {
+			n.logger.Errorf("[%s] sync with %s failed: %s", n.ListenAddr, v.ListenAddr, err)
+			return
+		}
+		if n.chain.Height() == height {
+			return
+		}
+	}
+}
+
+// syncWithPeer requests the blocks above our tip from the peer. A broken
+// stream is retried from the new tip, a block that fails validation is not.
+func (n *Node) syncWithPeer(c proto.NodeClient, v *proto.Version) error {
+	var err error
+	for attempt := 0; attempt < syncRetries; attempt++ {
+		err = n.downloadBlocks(c, int32(n.chain.Height()+1), v.Height)
+		if err == nil || errors.Is(err, errBlockRejected) {
+			return err
+		}
+		n.logger.Debugf("[%s] sync with %s interrupted at height %d: %s", n.ListenAddr, v.ListenAddr, n.chain.Height(), err)
+		time.Sleep(syncRetryDelay)
+	}
+	return err
+}
+
+// downloadBlocks adds the peer's blocks in the range to the chain. If the
+// peer is on another branch the first block is an orphan, so we walk back to
+// the fork point before the rest of the branch can connect.
+func (n *Node) downloadBlocks(c proto.NodeClient, from, to int32) error {
+	stream, err := c.GetBlocks(context.Background(), &proto.BlockRange{From: from, To: to})
+	if err != nil {
+		return err
+	}
+	requested := false
+	for {
+		b, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b.Header == nil {
+			return fmt.Errorf("%w: %s", errBlockRejected, errNoHeader)
+		}
+		err = n.chain.AddBlock(b)
+		if errors.Is(err, types.ErrOrphanBlock) {
+			if !requested {
+				requested = true
+				n.requestParent(c, b)
+			}
+			continue
+		}
+		if err != nil && !errors.Is(err, types.ErrKnownBlock) {
+			return fmt.Errorf("%w at height %d: %s", errBlockRejected, b.Header.Height, err)
+		}
+	}
+}
+
+// requestParent asks the peer that sent orphan b for its parent. If the
+// parent is an orphan as well we keep walking back until a block connects.
+func (n *Node) requestParent(c proto.NodeClient, b *proto.Block) {
+	for i := 0; i < maxParentRequests && b.Header.Height > 0; i++ {
+		parent, err := n.fetchBlock(c, b.Header.Height-1)
+		if err != nil {
+			n.logger.Errorf("[%s] unable to fetch parent of orphan block %s: %s", n.ListenAddr, hex.EncodeToString(types.HashBlock(b)), err)
+			return
+		}
+		err = n.chain.AddBlock(parent)
+		if !errors.Is(err, types.ErrOrphanBlock) {
+			if err != nil && !errors.Is(err, types.ErrKnownBlock) {
+				n.logger.Errorf("[%s] rejected parent block %s: %s", n.ListenAddr, hex.EncodeToString(types.HashBlock(parent)), err)
+			}
+			return
+		}
+		b = parent
+	}
+}
+
+func (n *Node) fetchBlock(c proto.NodeClient, height int32) (*proto.Block, error) {
+	stream, err := c.GetBlocks(context.Background(), &proto.BlockRange{From: height, To: height})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if b.Header == nil {
+		return nil, errNoHeader
+	}
+	return b, nil
+}
+
+// peerFromContext returns the connected peer that sent the request, based on
+// the listen address it attached to the call.
+func (n *Node) peerFromContext(ctx context.Context) proto.NodeClient {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(listenAddrKey)) == 0 {
+		return nil
+	}
+	addr := md.Get(listenAddrKey)[0]
+
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	for c, v := range n.peers {
+		if v.ListenAddr == addr {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *Node) tallestPeer() (proto.NodeClient, *proto.Version) {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	var (
+		best        proto.NodeClient
+		bestVersion *proto.Version
+	)
+	for c, v := range n.peers {
+		if bestVersion == nil || v.Height > bestVersion.Height {
+			best, bestVersion = c, v
+		}
+	}
+	return best, bestVersion
 }
 
 func (n *Node) broadcast(msg any) error {
+	n.peerLock.RLock()
+	peers := make([]proto.NodeClient, 0, len(n.peers))
 	for peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	n.peerLock.RUnlock()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), listenAddrKey, n.ListenAddr)
+	var errs []error
+	for _, peer := range peers {
+		var err error
 		switch v := msg.(type) {
 		case *proto.Transaction:
-			_, err := peer.HandleTransaction(context.Background(), v)
-			if err != nil {
-				return err
-			}
+			_, err = peer.HandleTransaction(ctx, v)
+		case *proto.Block:
+			_, err = peer.HandleBlock(ctx, v)
+		case *proto.Vote:
+			_, err = peer.HandleVote(ctx, v)
+		case *proto.Evidence:
+			_, err = peer.HandleEvidence(ctx, v)
+		}
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// rejectionStatus converts a mempool error into a gRPC status. Transactions
+// spending outputs this node does not know yet fail a precondition the
+// caller may retry later; every other rejection is final.
+func rejectionStatus(err error) error {
+	if errors.Is(err, ErrMissingInput) || errors.Is(err, types.ErrLockedOutput) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// blockRejectionStatus converts a chain error into a gRPC status. Blocks
+// rejected because of this node's state or clock fail a precondition; every
+// other rejection means the block itself is invalid.
+func blockRejectionStatus(err error) error {
+	if errors.Is(err, types.ErrKnownBlock) || errors.Is(err, types.ErrFinalized) || errors.Is(err, types.ErrTimestampTooNew) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
 }
 
 func makeNodeClietn(listenerAddr string) (proto.NodeClient, error) {
@@ -254,15 +746,18 @@ func (n *Node) bootstrapNetwork(addrs []string) error {
 
 		n.addPeer(*c, v)
 	}
+	n.syncChain()
 	return nil
 }
 
 func (n *Node) getVersion() *proto.Version {
+	tip := n.chain.Tip()
 	v := &proto.Version{
 		Version:    n.Version,
-		Height:     1,
+		Height:     tip.Height,
 		ListenAddr: n.ListenAddr,
 		PeerList:   n.getPeerList(),
+		TipHash:    types.HashHeader(tip),
 	}
 	return v
 }
@@ -0,0 +1,248 @@
+package node
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"blocker/types"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateBlockWithChainedTransactions(t *testing.T) {
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+
+	parent := spendGenesis(t, n.chain, 5)
+	child := spend(parent, 0, 5)
+	require.Nil(t, n.mempool.Add(parent))
+	require.Nil(t, n.mempool.Add(child))
+
+	block := n.createBlock([]*proto.Transaction{child, parent})
+	assert.Len(t, block.Transactions, 2)
+
+	block = n.createBlock(n.mempool.ByFeeRate())
+	require.Len(t, block.Transactions, 3)
+	assert.Equal(t, []*proto.Transaction{parent, child}, block.Transactions[1:])
+	assert.Equal(t, n.chain.Params().BlockReward+10, block.Transactions[0].Outputs[0].Amount)
+
+	require.Nil(t, n.chain.AddBlock(block))
+	assert.Equal(t, 0, n.mempool.Len())
+	utxo, err := n.chain.GetUTXO(types.HashTransaction(child), 0)
+	require.Nil(t, err)
+	assert.False(t, utxo.Spent)
+}
+
+func TestConnectedBlockDropsConflictingTransactions(t *testing.T) {
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+
+	pooled := spendGenesis(t, n.chain, 5)
+	require.Nil(t, n.mempool.Add(pooled))
+	require.Nil(t, n.mempool.Add(spend(pooled, 0, 5)))
+
+	block := n.createBlock([]*proto.Transaction{spendGenesis(t, n.chain, 6)})
+	require.Nil(t, n.chain.AddBlock(block))
+	assert.Equal(t, 0, n.mempool.Len())
+}
+
+func TestReorgReturnsTransactionsToMempool(t *testing.T) {
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+
+	parent := spendGenesis(t, n.chain, 5)
+	child := spend(parent, 0, 5)
+	require.Nil(t, n.chain.AddBlock(n.createBlock([]*proto.Transaction{parent, child})))
+
+	pooled := spend(child, 0, 5)
+	require.Nil(t, n.mempool.Add(pooled))
+
+	_, err := n.chain.RollbackTo(0)
+	require.Nil(t, err)
+	assert.Equal(t, []*proto.Transaction{parent, child, pooled}, n.mempool.ByFeeRate())
+}
+
+func TestStopPersistsMempool(t *testing.T) {
+	dir := t.TempDir()
+	n := NewNode(ServerConfig{ListenAddr: ":0", DataDir: dir})
+
+	tx := spendGenesis(t, n.chain, 5)
+	require.Nil(t, n.mempool.Add(tx))
+	require.Nil(t, n.Stop())
+
+	n = NewNode(ServerConfig{ListenAddr: ":0", DataDir: dir})
+	defer n.Stop()
+	assert.True(t, n.mempool.Has(tx))
+}
+
+func TestStopWaitsForValidatorLoop(t *testing.T) {
+	dir := t.TempDir()
+	paramsFile := filepath.Join(dir, "params.json")
+	require.Nil(t, os.WriteFile(paramsFile, []byte(`{"slotDuration": "1ms"}`), 0o644))
+	n := NewNode(ServerConfig{PrivateKey: crypto.GeneratePrivateKey(), DataDir: dir, ParamsFile: paramsFile})
+	go n.Start("127.0.0.1:0", nil)
+	require.Eventually(t, func() bool { return n.chain.Height() > 0 }, time.Second, time.Millisecond)
+
+	require.Nil(t, n.Stop())
+	height := n.chain.Height()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, height, n.chain.Height())
+}
+
+func TestSingleValidatorFinalizesBlocks(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	paramsFile := filepath.Join(t.TempDir(), "params.json")
+	data := `{"slotDuration": "1ms", "validators": ["` + hex.EncodeToString(key.Public().Bytes()) + `"]}`
+	require.Nil(t, os.WriteFile(paramsFile, []byte(data), 0o644))
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: key, ParamsFile: paramsFile})
+
+	block := n.createBlock(nil)
+	require.Nil(t, n.chain.AddBlock(block))
+	assert.Equal(t, 1, n.chain.FinalizedHeight())
+
+	other := crypto.GeneratePrivateKey()
+	vote := &proto.Vote{Type: proto.VoteType_PREVOTE, Height: 2, BlockHash: types.HashBlock(block)}
+	types.SignVote(other, vote)
+	_, err := n.HandleVote(context.Background(), vote)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandleBlockRejectionStatus(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: key})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}})
+
+	tampered := n.createBlock(nil)
+	tampered.Header.Nonce++
+	_, err := n.HandleBlock(ctx, tampered)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	future := n.createBlock(nil)
+	future.Header.Timestamp = time.Now().Add(time.Hour).UnixNano()
+	types.SignBlock(key, future)
+	_, err = n.HandleBlock(ctx, future)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = n.HandleBlock(ctx, n.createBlock(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n.chain.Height())
+}
+
+// headerlessServer answers every block request with a block without header.
+type headerlessServer struct {
+	proto.UnimplementedNodeServer
+}
+
+func (headerlessServer) GetBlocks(r *proto.BlockRange, stream proto.Node_GetBlocksServer) error {
+	return stream.Send(&proto.Block{})
+}
+
+func TestRejectBlockWithoutHeader(t *testing.T) {
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}})
+	_, err := n.HandleBlock(ctx, &proto.Block{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	server := grpc.NewServer()
+	proto.RegisterNodeServer(server, headerlessServer{})
+	go server.Serve(ln)
+	defer server.Stop()
+
+	c, err := makeNodeClietn(ln.Addr().String())
+	require.Nil(t, err)
+	assert.ErrorIs(t, n.downloadBlocks(c, 1, 1), errBlockRejected)
+	_, err = n.fetchBlock(c, 1)
+	assert.ErrorIs(t, err, errNoHeader)
+	assert.Equal(t, 0, n.chain.Height())
+}
+
+func TestSyncOntoLongerFork(t *testing.T) {
+	remote := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+	for i := 0; i < 3; i++ {
+		require.Nil(t, remote.chain.AddBlock(remote.createBlock(nil)))
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	server := grpc.NewServer()
+	proto.RegisterNodeServer(server, remote)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	c, err := makeNodeClietn(ln.Addr().String())
+	require.Nil(t, err)
+	stream, err := c.GetBlocks(context.Background(), &proto.BlockRange{From: 2, To: 10})
+	require.Nil(t, err)
+	var heights []int32
+	for {
+		b, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		heights = append(heights, b.Header.Height)
+	}
+	assert.Equal(t, []int32{2, 3}, heights)
+
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+	require.Nil(t, n.chain.AddBlock(n.createBlock(nil)))
+	n.addPeer(c, remote.getVersion())
+	n.syncChain()
+	assert.Equal(t, 3, n.chain.Height())
+	assert.Equal(t, remote.chain.Tip(), n.chain.Tip())
+}
+
+func TestQuorum(t *testing.T) {
+	for validators, expected := range map[int64]int64{1: 1, 2: 2, 3: 3, 4: 3, 7: 5} {
+		assert.Equal(t, expected, quorum(validators))
+	}
+}
+
+func TestHandleEvidenceSlashesOffender(t *testing.T) {
+	n := NewNode(ServerConfig{ListenAddr: ":0", PrivateKey: crypto.GeneratePrivateKey()})
+	offender := crypto.GeneratePrivateKey()
+
+	stake := spendGenesis(t, n.chain, 0)
+	stake.Type = proto.TxType_STAKE
+	stake.Validator = offender.Public().Bytes()
+	stake.Inputs[0].Signature = nil
+	stake.Inputs[0].Signature = types.SignTransaction(types.Factory{}.CreateGenesisPrivateKey(), stake).Bytes()
+	require.Nil(t, n.chain.AddBlock(n.createBlock([]*proto.Transaction{stake})))
+
+	first, second := n.createBlock(nil), n.createBlock(nil)
+	second.Header.Timestamp = first.Header.Timestamp + 1
+	types.SignBlock(offender, first)
+	types.SignBlock(offender, second)
+
+	_, err := n.HandleEvidence(context.Background(), &proto.Evidence{First: first, Second: first})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = n.HandleEvidence(context.Background(), &proto.Evidence{First: first, Second: second})
+	require.Nil(t, err)
+
+	block := n.createBlock(nil)
+	require.Len(t, block.Transactions, 2)
+	assert.Equal(t, proto.TxType_SLASH, block.Transactions[1].Type)
+	require.Nil(t, n.chain.AddBlock(block))
+
+	_, err = n.HandleEvidence(context.Background(), &proto.Evidence{First: first, Second: second})
+	assert.Nil(t, err)
+	assert.Len(t, n.createBlock(nil).Transactions, 1)
+
+	// A reorg hands the slash back to the evidence pool, not the mempool.
+	_, err = n.chain.RollbackTo(n.chain.Height() - 1)
+	require.Nil(t, err)
+	assert.Equal(t, 0, n.mempool.Len())
+	block = n.createBlock(nil)
+	require.Len(t, block.Transactions, 2)
+	assert.Equal(t, proto.TxType_SLASH, block.Transactions[1].Type)
+}
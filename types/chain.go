@@ -5,7 +5,10 @@ import (
 	"blocker/proto"
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
+	"sync"
 )
 
 const goldenSeed = "183d81f40dd7d9233696dfa5e6eb8a287b1370f236efe844b3ed6c8d4896f6ce"
@@ -25,8 +28,18 @@ func (l *HeaderList) Add(h *proto.Header) {
 	l.headers = append(l.headers, h)
 }
 
+// Pop removes and returns the last header in the list.
+func (l *HeaderList) Pop() *proto.Header {
+	if l.Len() == 0 {
+		return nil
+	}
+	header := l.headers[l.Len()-1]
+	l.headers = l.headers[:l.Len()-1]
+	return header
+}
+
 func (l *HeaderList) GetByHeight(height int) (*proto.Header, error) {
-	if height < 0 && height > l.Height() {
+	if height < 0 || height >= l.Len() {
 		return nil, fmt.Errorf("no block found at height %d", height)
 	}
 	header := l.headers[height]
@@ -47,81 +60,614 @@ type UTXO struct {
 	OutIndex int
 	Amount   int64
 	Spent    bool
+	// Owner is the address the output pays to. Only its key can spend it.
+	Owner []byte
+	// Validator is the public key a bonded or unbonding output is staked
+	// to.
+	Validator []byte
+	// LockedUntil is the first height at which an unbonding output can be
+	// spent.
+	LockedUntil int
+}
+
+func utxoKey(txHash string, outIndex int) string {
+	return fmt.Sprintf("%s_%d", txHash, outIndex)
+}
+
+// blockNode is an entry in the block index. The index holds every block the
+// chain has accepted, whether it is part of the main chain or a side branch.
+type blockNode struct {
+	hash   string
+	header *proto.Header
+	parent *blockNode
+	height int
+	// work is the total work of the chain ending at this block.
+	work    *big.Int
+	invalid bool
+}
+
+// Reorg describes a switch of the main chain from one branch to another.
+type Reorg struct {
+	// ForkHeight is the height of the last block both branches share.
+	ForkHeight int
+	// Disconnected holds the blocks removed from the old branch, tip first.
+	Disconnected []*proto.Block
+	// Connected holds the blocks applied from the new branch, in order.
+	Connected []*proto.Block
 }
 
 type Chain struct {
+	lock       sync.RWMutex
 	blockStore BlockStorer
 	txStore    TXStorer
 	uxtoStore  UTXOStorer
 	headers    *HeaderList
+	index      map[string]*blockNode
+	orphans    *OrphanPool
+	params     Params
+	// finalized is the last block the validators finalized; nil until the
+	// first finalization.
+	finalized *blockNode
+
+	reorgHandlers   []func(*Reorg)
+	connectHandlers []func(*proto.Block)
+	// connected holds the blocks connected to the main chain since the
+	// connect handlers last ran.
+	connected []*proto.Block
+
+	bondLock sync.Mutex
+	// bondCache holds the stake at each epoch boundary block.
+	bondCache map[string]*stakeState
 }
 
 func NewChain(bs BlockStorer, ts TXStorer) *Chain {
+	chain, err := OpenChain(bs, ts, NewMemoryUTXOStore())
+	if err != nil {
+		panic(err)
+	}
+	return chain
+}
+
+// OpenChain creates a chain on top of the given stores. If the block store
+// already holds a chain its main chain is rebuilt from the stored tip,
+// otherwise a new chain is started from the genesis block.
+func OpenChain(bs BlockStorer, ts TXStorer, us UTXOStorer) (*Chain, error) {
 	chain := &Chain{
 		blockStore: bs,
 		txStore:    ts,
-		uxtoStore:  NewMemoryUTXOStore(),
+		uxtoStore:  us,
 		headers:    NewHeaderList(),
+		index:      make(map[string]*blockNode),
+		orphans:    NewOrphanPool(maxOrphanBlocks, maxOrphanAge),
+		params:     DefaultParams(),
+		bondCache:  make(map[string]*stakeState),
 	}
-	chain.addBlock(createGenesisBlock())
-	return chain
+
+	tip, err := bs.GetTip()
+	if errors.Is(err, ErrNoTip) {
+		genesis := createGenesisBlock()
+		chain.addToIndex(genesis, nil)
+		err = chain.connectBlock(genesis)
+	} else if err == nil {
+		err = chain.load(tip)
+	}
+	chain.connected = nil
+	if err != nil {
+		return nil, err
+	}
+	return chain, nil
 }
 
-func (c *Chain) AddBlock(b *proto.Block) error {
-	if err := c.ValidateBlock(b); err != nil {
+// load rebuilds the header list and block index by walking back from the
+// stored tip to the genesis block.
+func (c *Chain) load(tip string) error {
+	blocks := []*proto.Block{}
+	hash := tip
+	for {
+		b, err := c.blockStore.Get(hash)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, b)
+		if len(b.Header.PreviousHash) == 0 {
+			break
+		}
+		hash = hex.EncodeToString(b.Header.PreviousHash)
+	}
+
+	genesis := blocks[len(blocks)-1]
+	if !bytes.Equal(HashBlock(genesis), HashBlock(createGenesisBlock())) {
+		return fmt.Errorf("stored chain has an unknown genesis block %s", hex.EncodeToString(HashBlock(genesis)))
+	}
+
+	var parent *blockNode
+	for i := len(blocks) - 1; i >= 0; i-- {
+		parent = c.addToIndex(blocks[i], parent)
+		c.headers.Add(blocks[i].Header)
+	}
+	if _, err := c.repairTip(); err != nil {
+		return err
+	}
+
+	finalized, err := c.blockStore.GetFinalized()
+	if err != nil || finalized == "" {
 		return err
 	}
-	return c.addBlock(b)
+	node, ok := c.index[finalized]
+	if !ok || !c.isMainChain(node) {
+		return fmt.Errorf("stored finalized block %s is not on the main chain", finalized)
+	}
+	c.finalized = node
+	return nil
 }
 
-func (c *Chain) addBlock(b *proto.Block) error {
-	c.headers.Add(b.Header)
+// SetParams changes the consensus rules used to validate new blocks. The
+// cached stake depends on the epoch length and unbonding period, so it is
+// dropped.
+func (c *Chain) SetParams(p Params) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.params = p
+	c.reindexWork()
+	c.bondLock.Lock()
+	c.bondCache = make(map[string]*stakeState)
+	c.bondLock.Unlock()
+}
 
-	for _, tx := range b.Transactions {
-		err := c.txStore.Put(tx)
+func (c *Chain) Params() Params {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.params
+}
+
+// OnReorg registers fn to be called after the main chain switches branches.
+// Handlers run after the chain lock is released.
+func (c *Chain) OnReorg(fn func(*Reorg)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reorgHandlers = append(c.reorgHandlers, fn)
+}
+
+// OnBlockConnected registers fn to be called for every block connected to
+// the main chain, whether it extends the tip or is applied during a reorg.
+// Handlers run after the chain lock is released and before reorg handlers.
+func (c *Chain) OnBlockConnected(fn func(*proto.Block)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connectHandlers = append(c.connectHandlers, fn)
+}
+
+// AddBlock accepts a block extending any known block. Blocks on the tip are
+// connected directly, blocks on a side branch are stored and the chain
+// reorganizes onto that branch once it has the most work. A block whose
+// parent is unknown is kept in the orphan pool, ErrOrphanBlock is returned,
+// and it is added automatically once its parent arrives.
+func (c *Chain) AddBlock(b *proto.Block) error {
+	c.lock.Lock()
+	reorgs := []*Reorg{}
+	reorg, err := c.acceptBlock(b)
+	if reorg != nil {
+		reorgs = append(reorgs, reorg)
+	}
+	if err == nil {
+		reorgs = append(reorgs, c.acceptOrphans(hex.EncodeToString(HashBlock(b)))...)
+	}
+	handlers, connectHandlers, connected := c.reorgHandlers, c.connectHandlers, c.connected
+	c.connected = nil
+	c.lock.Unlock()
+
+	for _, b := range connected {
+		for _, fn := range connectHandlers {
+			fn(b)
+		}
+	}
+	for _, reorg := range reorgs {
+		for _, fn := range handlers {
+			fn(reorg)
+		}
+	}
+	return err
+}
+
+// HasBlock reports whether the block is in the index or the orphan pool.
+func (c *Chain) HasBlock(hash []byte) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	h := hex.EncodeToString(hash)
+	_, ok := c.index[h]
+	return ok || c.orphans.Has(h)
+}
+
+func (c *Chain) acceptBlock(b *proto.Block) (*Reorg, error) {
+	hash := hex.EncodeToString(HashBlock(b))
+	if _, ok := c.index[hash]; ok || c.orphans.Has(hash) {
+		return nil, fmt.Errorf("%w: %s", ErrKnownBlock, hash)
+	}
+	parent, ok := c.index[hex.EncodeToString(b.Header.PreviousHash)]
+	if !ok {
+		if err := c.checkOrphan(b); err != nil {
+			return nil, err
+		}
+		c.orphans.Add(b)
+		return nil, fmt.Errorf("%w: %s is missing parent %s", ErrOrphanBlock, hash, hex.EncodeToString(b.Header.PreviousHash))
+	}
+	if parent.invalid {
+		return nil, fmt.Errorf("block %s extends an invalid block", hash)
+	}
+	if parent.height < c.finalizedHeight() {
+		return nil, fmt.Errorf("%w: block %s at height %d forks below finalized height %d", ErrFinalized, hash, parent.height+1, c.finalizedHeight())
+	}
+
+	tip := c.tip()
+	if parent == tip {
+		if err := c.validateBlock(b); err != nil {
+			return nil, err
+		}
+		node := c.addToIndex(b, parent)
+		if err := c.connectBlock(b); err != nil {
+			delete(c.index, node.hash)
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	verified, err := VerifyBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return nil, fmt.Errorf("unable to verify block")
+	}
+	set, err := c.validatorSet(parent)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.params.validateLeader(set, b, parent.header); err != nil {
+		return nil, err
+	}
+	if err := validateHeader(b, parent); err != nil {
+		return nil, err
+	}
+	if err := c.validateWork(b, parent); err != nil {
+		return nil, err
+	}
+	node := c.addToIndex(b, parent)
+	if err := c.blockStore.Put(b); err != nil {
+		return nil, err
+	}
+	if node.work.Cmp(tip.work) <= 0 {
+		return nil, nil
+	}
+	return c.reorganize(node)
+}
+
+// acceptOrphans adds the orphans that were waiting for parentHash, and in
+// turn the orphans waiting for them.
+func (c *Chain) acceptOrphans(parentHash string) []*Reorg {
+	reorgs := []*Reorg{}
+	queue := []string{parentHash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		for _, b := range c.orphans.TakeChildren(hash) {
+			reorg, err := c.acceptBlock(b)
+			if err != nil {
+				continue
+			}
+			if reorg != nil {
+				reorgs = append(reorgs, reorg)
+			}
+			queue = append(queue, hex.EncodeToString(HashBlock(b)))
+		}
+	}
+	return reorgs
+}
+
+func (c *Chain) addToIndex(b *proto.Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		hash:   hex.EncodeToString(HashBlock(b)),
+		header: b.Header,
+		parent: parent,
+		work:   c.blockWork(b.Header.Bits),
+	}
+	if parent != nil {
+		node.height = parent.height + 1
+		node.work.Add(node.work, parent.work)
+	}
+	c.index[node.hash] = node
+	return node
+}
+
+func (c *Chain) tip() *blockNode {
+	header, err := c.headers.GetByHeight(c.height())
+	if err != nil {
+		panic(err)
+	}
+	return c.index[hex.EncodeToString(HashHeader(header))]
+}
+
+func (c *Chain) isMainChain(node *blockNode) bool {
+	header, err := c.headers.GetByHeight(node.height)
+	if err != nil {
+		return false
+	}
+	return hex.EncodeToString(HashHeader(header)) == node.hash
+}
+
+// reorganize switches the main chain to the branch ending at newTip. If a
+// block on the new branch fails validation the old branch is restored and
+// the failing block is marked invalid.
+func (c *Chain) reorganize(newTip *blockNode) (*Reorg, error) {
+	fork := newTip
+	for !c.isMainChain(fork) {
+		fork = fork.parent
+	}
+	if fork.height < c.finalizedHeight() {
+		return nil, fmt.Errorf("%w: branch forks at height %d below finalized height %d", ErrFinalized, fork.height, c.finalizedHeight())
+	}
+	branch := []*blockNode{}
+	for node := newTip; node != fork; node = node.parent {
+		branch = append([]*blockNode{node}, branch...)
+	}
+
+	reorg := &Reorg{ForkHeight: fork.height}
+	for c.height() > fork.height {
+		b, err := c.disconnectTip()
+		if err != nil {
+			return nil, err
+		}
+		reorg.Disconnected = append(reorg.Disconnected, b)
+	}
+
+	for _, node := range branch {
+		b, err := c.blockStore.Get(node.hash)
+		if err == nil {
+			if err = c.validateBlock(b); err == nil {
+				err = c.connectBlock(b)
+			}
+		}
 		if err != nil {
+			node.invalid = true
+			if rerr := c.restoreBranch(reorg); rerr != nil {
+				return nil, rerr
+			}
+			return nil, fmt.Errorf("reorganization to %s failed at height %d: %s", newTip.hash, node.height, err)
+		}
+		reorg.Connected = append(reorg.Connected, b)
+	}
+	return reorg, nil
+}
+
+// restoreBranch undoes a partially applied reorganization.
+func (c *Chain) restoreBranch(reorg *Reorg) error {
+	for range reorg.Connected {
+		if _, err := c.disconnectTip(); err != nil {
+			return err
+		}
+	}
+	for i := len(reorg.Disconnected) - 1; i >= 0; i-- {
+		if err := c.connectBlock(reorg.Disconnected[i]); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// disconnectTip removes the tip from the main chain and uses its undo data
+// to restore the UTXO set to the state before the block was applied.
+func (c *Chain) disconnectTip() (*proto.Block, error) {
+	if c.height() == 0 {
+		return nil, fmt.Errorf("cannot disconnect the genesis block")
+	}
+	b, err := c.getBlockByHeight(c.height())
+	if err != nil {
+		return nil, err
+	}
+	hash := hex.EncodeToString(HashBlock(b))
+	undo, err := c.blockStore.GetUndo(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tip moves last so an interrupted write leaves the old tip
+	// partially reverted, which repairTip can detect and reapply.
+	batch := c.newBatch()
+	for i := len(undo.Spent) - 1; i >= 0; i-- {
+		batch.PutUTXO(undo.Spent[i])
+	}
+	for _, key := range undo.Created {
+		batch.DeleteUTXO(key)
+	}
+	batch.PutTip(hex.EncodeToString(b.Header.PreviousHash))
+	if err := batch.Commit(); err != nil {
+		return nil, err
+	}
+	c.headers.Pop()
+	// A block connected and disconnected again within one call, such as
+	// the invalid branch of a failed reorg, is never reported.
+	if n := len(c.connected); n > 0 && hex.EncodeToString(HashBlock(c.connected[n-1])) == hash {
+		c.connected = c.connected[:n-1]
+	}
+	return b, nil
+}
+
+// connectBlock appends b to the main chain, applies its UTXO changes and
+// records the undo data needed to disconnect it again.
+func (c *Chain) connectBlock(b *proto.Block) error {
+	hash := hex.EncodeToString(HashBlock(b))
+
+	// The tip moves before the UTXOs so an interrupted write leaves the new
+	// tip partially applied, which repairTip can detect and reapply.
+	undo := &BlockUndo{}
+	batch := c.newBatch()
+	batch.PutBlock(b)
+	batch.PutUndo(hash, undo)
+	batch.PutTip(hash)
+	if err := c.applyTransactions(batch, b, c.height()+1, undo); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	c.headers.Add(b.Header)
+	c.connected = append(c.connected, b)
+	return nil
+}
+
+// applyTransactions adds the UTXO changes of b, the block at height, to the
+// batch and records the undo data for them in undo.
+func (c *Chain) applyTransactions(batch *Batch, b *proto.Block, height int, undo *BlockUndo) error {
+	for _, tx := range b.Transactions {
+		batch.PutTx(tx)
 
 		hash := hex.EncodeToString(HashTransaction(tx))
-		for idx, output := range tx.Outputs {
-			utxo := &UTXO{
-				Hash:     hash,
-				OutIndex: idx,
-				Amount:   output.Amount,
-				Spent:    false,
-			}
-			c.uxtoStore.Put(utxo)
+		for idx := range tx.Outputs {
+			batch.PutUTXO(c.params.OutputUTXO(tx, hash, idx, height))
+			undo.Created = append(undo.Created, utxoKey(hash, idx))
 		}
 
-		for i, input := range tx.Inputs {
-			key := fmt.Sprintf("%s_%d", hex.EncodeToString(input.PrevTxHash), i)
-			utxo, err := c.uxtoStore.Get(key)
+		for _, input := range tx.Inputs {
+			utxo, err := batch.GetUTXO(utxoKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex)))
 			if err != nil {
 				return err
 			}
-			utxo.Spent = true
-			c.uxtoStore.Put(utxo)
+			// A block may only spend unspent outputs, so that is the state
+			// to restore even when a partially applied block is reapplied.
+			prev := *utxo
+			prev.Spent = false
+			undo.Spent = append(undo.Spent, &prev)
+
+			spent := *utxo
+			spent.Spent = true
+			batch.PutUTXO(&spent)
+		}
+	}
+	return nil
+}
+
+func (c *Chain) newBatch() *Batch {
+	return NewBatch(c.blockStore, c.txStore, c.uxtoStore)
+}
+
+// repairTip checks that the UTXO changes of the tip block are fully applied
+// and reapplies them if a write was interrupted half way.
+func (c *Chain) repairTip() (bool, error) {
+	b, err := c.getBlockByHeight(c.height())
+	if err != nil {
+		return false, err
+	}
+	if c.isApplied(b) {
+		return false, nil
+	}
+
+	undo := &BlockUndo{}
+	batch := c.newBatch()
+	batch.PutUndo(hex.EncodeToString(HashBlock(b)), undo)
+	if err := c.applyTransactions(batch, b, c.height(), undo); err != nil {
+		return false, err
+	}
+	return true, batch.Commit()
+}
+
+func (c *Chain) isApplied(b *proto.Block) bool {
+	for _, tx := range b.Transactions {
+		hash := hex.EncodeToString(HashTransaction(tx))
+		if _, err := c.txStore.Get(hash); err != nil {
+			return false
+		}
+		for idx := range tx.Outputs {
+			if _, err := c.uxtoStore.Get(utxoKey(hash, idx)); err != nil {
+				return false
+			}
+		}
+		for _, input := range tx.Inputs {
+			utxo, err := c.uxtoStore.Get(utxoKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex)))
+			if err != nil || !utxo.Spent {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DisconnectTip removes the last block from the main chain and restores the
+// UTXO set to its state before the block. The block is marked invalid so the
+// chain does not reorganize back onto it.
+func (c *Chain) DisconnectTip() (*proto.Block, error) {
+	blocks, err := c.RollbackTo(c.Height() - 1)
+	if err != nil {
+		return nil, err
+	}
+	return blocks[0], nil
+}
+
+// RollbackTo disconnects blocks until the tip is at the given height and
+// returns the disconnected blocks, tip first. Reorg handlers are notified
+// with the disconnected blocks and no connected ones.
+func (c *Chain) RollbackTo(height int) ([]*proto.Block, error) {
+	c.lock.Lock()
+	if height < 0 || height >= c.height() {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("cannot roll back to height %d from height %d", height, c.height())
+	}
+	if height < c.finalizedHeight() {
+		c.lock.Unlock()
+		return nil, fmt.Errorf("%w: cannot roll back to height %d below finalized height %d", ErrFinalized, height, c.finalizedHeight())
+	}
+	reorg := &Reorg{ForkHeight: height}
+	var err error
+	for c.height() > height {
+		var b *proto.Block
+		if b, err = c.disconnectTip(); err != nil {
+			break
 		}
+		c.index[hex.EncodeToString(HashBlock(b))].invalid = true
+		reorg.Disconnected = append(reorg.Disconnected, b)
 	}
-	return c.blockStore.Put(b)
+	handlers := c.reorgHandlers
+	c.lock.Unlock()
+
+	if len(reorg.Disconnected) > 0 {
+		for _, fn := range handlers {
+			fn(reorg)
+		}
+	}
+	return reorg.Disconnected, err
 }
 
 func (c *Chain) Height() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.height()
+}
+
+func (c *Chain) height() int {
 	return c.headers.Height() - 1
 }
 
-func (c *Chain) GetBlockByHeight(height int) (*proto.Block, error) {
-	header, err := c.headers.GetByHeight(height)
+// Tip returns the header of the last block in the chain.
+func (c *Chain) Tip() *proto.Header {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	header, err := c.headers.GetByHeight(c.height())
 	if err != nil {
 		panic(err)
 	}
-	block, err := c.GetBlockByHash(HashHeader(header))
+	return header
+}
+
+func (c *Chain) GetBlockByHeight(height int) (*proto.Block, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.getBlockByHeight(height)
+}
+
+func (c *Chain) getBlockByHeight(height int) (*proto.Block, error) {
+	header, err := c.headers.GetByHeight(height)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	return block, nil
+	return c.GetBlockByHash(HashHeader(header))
 }
 
 func (c *Chain) GetBlockByHash(hash []byte) (*proto.Block, error) {
@@ -129,7 +675,21 @@ func (c *Chain) GetBlockByHash(hash []byte) (*proto.Block, error) {
 	return c.blockStore.Get(hasHex)
 }
 
+// GetUTXO returns the output at outIndex of the transaction with the given
+// hash, including whether it has been spent.
+func (c *Chain) GetUTXO(txHash []byte, outIndex int) (*UTXO, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.uxtoStore.Get(utxoKey(hex.EncodeToString(txHash), outIndex))
+}
+
 func (c *Chain) ValidateBlock(b *proto.Block) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.validateBlock(b)
+}
+
+func (c *Chain) validateBlock(b *proto.Block) error {
 	// validate the signature of the block
 	verified, err := VerifyBlock(b)
 	if err != nil {
@@ -140,7 +700,7 @@ func (c *Chain) ValidateBlock(b *proto.Block) error {
 	}
 
 	// validate if the prevHas is the actual has of the current block
-	currentBlock, err := c.GetBlockByHeight(c.Height())
+	currentBlock, err := c.getBlockByHeight(c.height())
 	if err != nil {
 		return err
 	}
@@ -148,57 +708,77 @@ func (c *Chain) ValidateBlock(b *proto.Block) error {
 	if !bytes.Equal(hash, b.Header.PreviousHash) {
 		return fmt.Errorf("invlid previous hash")
 	}
+	set, err := c.validatorSet(c.tip())
+	if err != nil {
+		return err
+	}
+	if err := c.params.validateLeader(set, b, currentBlock.Header); err != nil {
+		return err
+	}
+	if err := validateHeader(b, c.tip()); err != nil {
+		return err
+	}
+	if err := c.validateWork(b, c.tip()); err != nil {
+		return err
+	}
 
-	// validate transactions
-	for _, tx := range b.Transactions {
-		if err := c.ValidateTransaction(tx); err != nil {
-
-		}
+	fees, err := c.validateTransactions(b, c.tip())
+	if err != nil {
+		return err
 	}
-	return nil
+	return c.validateCoinbase(b, c.height()+1, fees)
 }
 
-func (c *Chain) ValidateTransaction(tx *proto.Transaction) error {
-	if !VerifyTransaction(tx) {
-		return fmt.Errorf("invalid tx ")
+// validateCoinbase checks that the block starts with the only coinbase
+// transaction, that it belongs to the block's height and that it mints
+// exactly the block reward plus the fees of the block.
+func (c *Chain) validateCoinbase(b *proto.Block, height int, fees int64) error {
+	if len(b.Transactions) == 0 || !IsCoinbase(b.Transactions[0]) {
+		return fmt.Errorf("block has no coinbase transaction")
 	}
-
-	txHash := hex.EncodeToString(HashTransaction(tx))
-	// check if all outputs are present and unspent
-	nOutputs := len(tx.Outputs)
-	var sumOutputs int64
-	for i := 0; i < nOutputs; i++ {
-		key := fmt.Sprintf("%s_%d", txHash, i)
-		utxo, err := c.uxtoStore.Get(key)
-		if err != nil {
-			return err
-		}
-		if utxo.Spent {
-			return fmt.Errorf("output is already spent")
+	for i, tx := range b.Transactions[1:] {
+		if IsCoinbase(tx) {
+			return fmt.Errorf("transaction %d is a coinbase, only the first transaction can be", i+1)
 		}
-		sumOutputs += tx.Outputs[i].Amount
 	}
 
-	// check if all inputs are unspent
-	nInputs := len(tx.Inputs)
-	var sumInputs int64
-	for i := 0; i < nInputs; i++ {
-		key := fmt.Sprintf("%s_%d", hex.EncodeToString(tx.Inputs[i].PrevTxHash), tx.Inputs[i].PrevOutIndex)
-		utxo, err := c.uxtoStore.Get(key)
-		if err != nil {
-			return err
-		}
-		if utxo.Spent {
-			return fmt.Errorf("input is already delayed")
-		}
-		sumInputs += utxo.Amount
+	coinbase := b.Transactions[0]
+	if coinbase.Type != proto.TxType_TRANSFER || len(coinbase.Validator) != 0 {
+		return fmt.Errorf("coinbase transaction must be a plain transfer")
+	}
+	if err := CheckOutputs(coinbase); err != nil {
+		return fmt.Errorf("coinbase: %w", err)
 	}
-	if sumInputs != sumOutputs {
-		return fmt.Errorf("insufficient balance inputs are %d and outputs are %d", sumInputs, sumOutputs)
+	if int(coinbase.Height) != height {
+		return fmt.Errorf("coinbase height %d does not match block height %d", coinbase.Height, height)
+	}
+	var minted int64
+	for _, output := range coinbase.Outputs {
+		minted += output.Amount
+	}
+	if expected := c.params.BlockReward + fees; minted != expected {
+		return fmt.Errorf("coinbase pays %d, expected %d", minted, expected)
 	}
 	return nil
 }
 
+func (c *Chain) ValidateTransaction(tx *proto.Transaction) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.validateTransaction(tx)
+}
+
+// validateTransaction checks that tx can be included in the block after the
+// tip.
+func (c *Chain) validateTransaction(tx *proto.Transaction) error {
+	view, err := c.newBlockView(c.tip())
+	if err != nil {
+		return err
+	}
+	_, err = view.Add(tx)
+	return err
+}
+
 func createGenesisBlock() *proto.Block {
 	privKey := crypto.NewPrivateKeyFromString(goldenSeed)
 
@@ -7,19 +7,15 @@ import (
 	"encoding/hex"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func randomBlock(chain *Chain) *proto.Block {
-	block := util.RandomBlock()
 	prevBlock, _ := chain.GetBlockByHeight(chain.Height())
-	block.Header.PreviousHash = HashBlock(prevBlock)
-	pk := crypto.GeneratePrivateKey()
-	SignBlock(pk, block)
-
-	return block
+	return blockOn(prevBlock)
 }
 
 func TestNewChain(t *testing.T) {
@@ -144,7 +140,7 @@ func TestAddBlockWithTx(t *testing.T) {
 		Outputs: outputs,
 	}
 
-	block.Transactions = []*proto.Transaction{&tx}
+	block.Transactions = append(block.Transactions, &tx)
 
 	tree, err := GetMerkleTree(block)
 	assert.Nil(t, err)
@@ -162,7 +158,7 @@ func TestAddBlockWithTx(t *testing.T) {
 	fetchedTx, err := chain.txStore.Get(txHash)
 	assert.Nil(t, err)
 	assert.NotNil(t, fetchedTx)
-	assert.Equal(t, tx, *fetchedTx)
+	assert.Equal(t, &tx, fetchedTx)
 
 	nOutputs := len(tx.Outputs)
 	for i := 0; i < nOutputs; i++ {
@@ -212,8 +208,9 @@ func TestValidateTransaction(t *testing.T) {
 	}
 
 	inputs[0].Signature = privKey.Sign(HashTransaction(&tx)).Bytes()
+	assert.Nil(t, chain.ValidateTransaction(&tx))
 
-	block.Transactions = []*proto.Transaction{&tx}
+	block.Transactions = append(block.Transactions, &tx)
 
 	SignBlock(privKey, block)
 
@@ -221,7 +218,8 @@ func TestValidateTransaction(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, 1, chain.Height())
 
-	assert.Nil(t, chain.ValidateTransaction(&tx))
+	// Once included its input is spent.
+	assert.ErrorIs(t, chain.ValidateTransaction(&tx), ErrSpentInput)
 }
 
 func TestMarkInputsAsSpent(t *testing.T) {
@@ -263,7 +261,7 @@ func TestMarkInputsAsSpent(t *testing.T) {
 
 	inputs[0].Signature = privKey.Sign(HashTransaction(&tx)).Bytes()
 
-	block.Transactions = []*proto.Transaction{&tx}
+	block.Transactions = append(block.Transactions, &tx)
 
 	SignBlock(privKey, block)
 
@@ -315,14 +313,440 @@ func TestAddTransactionWithInsufficientInputs(t *testing.T) {
 
 	inputs[0].Signature = privKey.Sign(HashTransaction(&tx)).Bytes()
 
-	block.Transactions = []*proto.Transaction{&tx}
+	block.Transactions = append(block.Transactions, &tx)
 
 	SignBlock(privKey, block)
 	err = chain.AddBlock(block)
-	require.Nil(t, err)
-	require.Equal(t, 1, chain.Height())
+	assert.ErrorIs(t, err, ErrInsufficientInputs)
+	var txErr *TxError
+	require.ErrorAs(t, err, &txErr)
+	assert.Equal(t, len(block.Transactions)-1, txErr.Index)
+	require.Equal(t, 0, chain.Height())
 
 	err = chain.ValidateTransaction(&tx)
+	assert.ErrorIs(t, err, ErrInsufficientInputs)
+	assert.EqualError(t, err, "outputs exceed inputs: inputs are 1000 and outputs are 1900")
+}
+
+func TestTip(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, err := chain.GetBlockByHeight(0)
+	require.Nil(t, err)
+	assert.Equal(t, genesis.Header, chain.Tip())
+
+	block := randomBlock(chain)
+	require.Nil(t, chain.AddBlock(block))
+	assert.Equal(t, block.Header, chain.Tip())
+	assert.Equal(t, HashBlock(block), HashHeader(chain.Tip()))
+}
+
+func TestGetBlockByHeightOutOfRange(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+
+	_, err := chain.GetBlockByHeight(1)
 	assert.NotNil(t, err)
-	assert.Equal(t, fmt.Errorf("insufficient balance inputs are 1000 and outputs are 1900"), err)
+
+	_, err = chain.GetBlockByHeight(-1)
+	assert.NotNil(t, err)
+}
+
+// blockOn creates a signed block on top of parent with a coinbase paying the
+// block reward followed by txx, which must not pay any fees.
+func blockOn(parent *proto.Block, txx ...*proto.Transaction) *proto.Block {
+	block := util.RandomBlock()
+	block.Header.Height = parent.Header.Height + 1
+	block.Header.PreviousHash = HashBlock(parent)
+	coinbase := NewCoinbaseTransaction(Factory{}.CreateAddress(), block.Header.Height, defaultBlockReward)
+	block.Transactions = append([]*proto.Transaction{coinbase}, txx...)
+	SignBlock(crypto.GeneratePrivateKey(), block)
+
+	return block
+}
+
+func spendGenesis(chain *Chain) *proto.Transaction {
+	privKey := Factory{}.CreateGenesisPrivateKey()
+	genesis, _ := chain.GetBlockByHeight(0)
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{
+				PrevTxHash:   HashTransaction(genesis.Transactions[0]),
+				PublicKey:    privKey.Public().Bytes(),
+				PrevOutIndex: 0,
+			},
+		},
+		Outputs: []*proto.TxOutput{
+			{
+				Amount:    genesisSupply,
+				ToAddress: Factory{}.CreateAddress(),
+			},
+		},
+	}
+	tx.Inputs[0].Signature = SignTransaction(privKey, tx).Bytes()
+	return tx
+}
+
+func TestAddBlockOnSideBranch(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	a1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(a1))
+	b1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(b1))
+
+	assert.Equal(t, 1, chain.Height())
+	assert.Equal(t, a1.Header, chain.Tip())
+
+	stored, err := chain.GetBlockByHash(HashBlock(b1))
+	assert.Nil(t, err)
+	assert.Equal(t, b1, stored)
+
+	assert.NotNil(t, chain.AddBlock(b1))
+}
+
+func TestReorg(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	var reorgs []*Reorg
+	chain.OnReorg(func(r *Reorg) {
+		reorgs = append(reorgs, r)
+	})
+
+	a1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(a1))
+	b1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(b1))
+	b2 := blockOn(b1)
+	require.Nil(t, chain.AddBlock(b2))
+
+	assert.Equal(t, 2, chain.Height())
+	assert.Equal(t, b2.Header, chain.Tip())
+	actual, err := chain.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1, actual)
+
+	require.Equal(t, 1, len(reorgs))
+	assert.Equal(t, 0, reorgs[0].ForkHeight)
+	assert.Equal(t, []*proto.Block{a1}, reorgs[0].Disconnected)
+	assert.Equal(t, []*proto.Block{b1, b2}, reorgs[0].Connected)
+}
+
+func TestReorgRevertsUTXOs(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	genesisKey := fmt.Sprintf("%s_0", hex.EncodeToString(HashTransaction(genesis.Transactions[0])))
+
+	tx := spendGenesis(chain)
+	txKey := fmt.Sprintf("%s_0", hex.EncodeToString(HashTransaction(tx)))
+	a1 := blockOn(genesis, tx)
+	require.Nil(t, chain.AddBlock(a1))
+
+	utxo, err := chain.uxtoStore.Get(genesisKey)
+	require.Nil(t, err)
+	assert.True(t, utxo.Spent)
+	_, err = chain.uxtoStore.Get(txKey)
+	assert.Nil(t, err)
+
+	b1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(b1))
+	require.Nil(t, chain.AddBlock(blockOn(b1)))
+
+	utxo, err = chain.uxtoStore.Get(genesisKey)
+	require.Nil(t, err)
+	assert.False(t, utxo.Spent)
+	_, err = chain.uxtoStore.Get(txKey)
+	assert.NotNil(t, err)
+}
+
+func TestAddOrphanBlock(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	b1 := blockOn(genesis)
+	b2 := blockOn(b1)
+	b3 := blockOn(b2)
+
+	assert.ErrorIs(t, chain.AddBlock(b3), ErrOrphanBlock)
+	assert.ErrorIs(t, chain.AddBlock(b2), ErrOrphanBlock)
+	assert.ErrorIs(t, chain.AddBlock(b2), ErrKnownBlock)
+	assert.True(t, chain.HasBlock(HashBlock(b3)))
+	assert.Equal(t, 0, chain.Height())
+
+	require.Nil(t, chain.AddBlock(b1))
+	assert.Equal(t, 3, chain.Height())
+	assert.Equal(t, b3.Header, chain.Tip())
+	assert.Equal(t, 0, chain.orphans.Len())
+}
+
+func TestRejectImplausibleOrphans(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	b1 := blockOn(genesis)
+
+	tampered := blockOn(b1)
+	tampered.Header.Nonce++
+	assert.NotNil(t, chain.AddBlock(tampered))
+	assert.False(t, chain.HasBlock(HashBlock(tampered)))
+
+	far := blockOn(b1)
+	far.Header.Height = maxOrphanBlocks + 1
+	SignBlock(crypto.GeneratePrivateKey(), far)
+	assert.ErrorIs(t, chain.AddBlock(far), ErrBadHeight)
+	assert.False(t, chain.HasBlock(HashBlock(far)))
+
+	future := blockOn(b1)
+	future.Header.Timestamp = time.Now().Add(time.Hour).UnixNano()
+	SignBlock(crypto.GeneratePrivateKey(), future)
+	assert.ErrorIs(t, chain.AddBlock(future), ErrTimestampTooNew)
+	assert.Equal(t, 0, chain.orphans.Len())
+
+	assert.ErrorIs(t, chain.AddBlock(blockOn(b1)), ErrOrphanBlock)
+	assert.Equal(t, 1, chain.orphans.Len())
+}
+
+func TestAddBlockIsAtomic(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	genesisKey := utxoKey(hex.EncodeToString(HashTransaction(genesis.Transactions[0])), 0)
+
+	tx := spendGenesis(chain)
+	bad := Factory{}.CreateTransaction()
+	block := blockOn(genesis, tx, bad)
+
+	assert.NotNil(t, chain.AddBlock(block))
+	assert.Equal(t, 0, chain.Height())
+	assert.False(t, chain.HasBlock(HashBlock(block)))
+
+	utxo, err := chain.uxtoStore.Get(genesisKey)
+	require.Nil(t, err)
+	assert.False(t, utxo.Spent)
+	_, err = chain.uxtoStore.Get(utxoKey(hex.EncodeToString(HashTransaction(tx)), 0))
+	assert.NotNil(t, err)
+	_, err = chain.txStore.Get(hex.EncodeToString(HashTransaction(tx)))
+	assert.NotNil(t, err)
+}
+
+func TestOpenChainRepairsPartialTip(t *testing.T) {
+	bs, ts, us := NewMemoryBlockStore(), NewMemoryTXStore(), NewMemoryUTXOStore()
+	chain, err := OpenChain(bs, ts, us)
+	require.Nil(t, err)
+	genesis, _ := chain.GetBlockByHeight(0)
+	genesisKey := utxoKey(hex.EncodeToString(HashTransaction(genesis.Transactions[0])), 0)
+
+	tx := spendGenesis(chain)
+	txKey := utxoKey(hex.EncodeToString(HashTransaction(tx)), 0)
+	require.Nil(t, chain.AddBlock(blockOn(genesis, tx)))
+
+	// simulate a crash after the tip moved but before the UTXOs were written
+	require.Nil(t, us.Delete(txKey))
+	require.Nil(t, us.Put(&UTXO{Hash: hex.EncodeToString(HashTransaction(genesis.Transactions[0])), OutIndex: 0, Amount: genesisSupply}))
+
+	chain, err = OpenChain(bs, ts, us)
+	require.Nil(t, err)
+	assert.Equal(t, 1, chain.Height())
+
+	utxo, err := us.Get(genesisKey)
+	require.Nil(t, err)
+	assert.True(t, utxo.Spent)
+	_, err = us.Get(txKey)
+	assert.Nil(t, err)
+}
+
+func TestConnectBlockRecordsUndo(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	genesisKey := utxoKey(hex.EncodeToString(HashTransaction(genesis.Transactions[0])), 0)
+
+	tx := spendGenesis(chain)
+	block := blockOn(genesis, tx)
+	require.Nil(t, chain.AddBlock(block))
+
+	undo, err := chain.blockStore.GetUndo(hex.EncodeToString(HashBlock(block)))
+	require.Nil(t, err)
+	coinbaseKey := utxoKey(hex.EncodeToString(HashTransaction(block.Transactions[0])), 0)
+	assert.Equal(t, []string{coinbaseKey, utxoKey(hex.EncodeToString(HashTransaction(tx)), 0)}, undo.Created)
+	require.Equal(t, 1, len(undo.Spent))
+	assert.Equal(t, genesisKey, utxoKey(undo.Spent[0].Hash, undo.Spent[0].OutIndex))
+	assert.False(t, undo.Spent[0].Spent)
+}
+
+func TestDisconnectTip(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	genesisKey := utxoKey(hex.EncodeToString(HashTransaction(genesis.Transactions[0])), 0)
+
+	tx := spendGenesis(chain)
+	txKey := utxoKey(hex.EncodeToString(HashTransaction(tx)), 0)
+	block := blockOn(genesis, tx)
+	require.Nil(t, chain.AddBlock(block))
+
+	var reorgs []*Reorg
+	chain.OnReorg(func(r *Reorg) {
+		reorgs = append(reorgs, r)
+	})
+
+	disconnected, err := chain.DisconnectTip()
+	require.Nil(t, err)
+	assert.Equal(t, block, disconnected)
+	assert.Equal(t, 0, chain.Height())
+	assert.Equal(t, genesis.Header, chain.Tip())
+
+	utxo, err := chain.uxtoStore.Get(genesisKey)
+	require.Nil(t, err)
+	assert.False(t, utxo.Spent)
+	_, err = chain.uxtoStore.Get(txKey)
+	assert.NotNil(t, err)
+
+	require.Equal(t, 1, len(reorgs))
+	assert.Equal(t, []*proto.Block{block}, reorgs[0].Disconnected)
+	assert.Empty(t, reorgs[0].Connected)
+
+	assert.NotNil(t, chain.AddBlock(blockOn(block)))
+
+	_, err = chain.DisconnectTip()
+	assert.NotNil(t, err)
+}
+
+func TestRollbackTo(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	for i := 0; i < 5; i++ {
+		require.Nil(t, chain.AddBlock(randomBlock(chain)))
+	}
+	tip := chain.Tip()
+
+	blocks, err := chain.RollbackTo(2)
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(blocks))
+	assert.Equal(t, tip, blocks[0].Header)
+	assert.Equal(t, 2, chain.Height())
+
+	_, err = chain.RollbackTo(4)
+	assert.NotNil(t, err)
+
+	require.Nil(t, chain.AddBlock(randomBlock(chain)))
+	assert.Equal(t, 3, chain.Height())
+}
+
+func TestValidateCoinbase(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	privKey := crypto.GeneratePrivateKey()
+	to := Factory{}.CreateAddress()
+
+	noCoinbase := blockOn(genesis)
+	noCoinbase.Transactions = []*proto.Transaction{}
+	SignBlock(privKey, noCoinbase)
+	assert.NotNil(t, chain.AddBlock(noCoinbase))
+
+	twoCoinbases := blockOn(genesis)
+	twoCoinbases.Transactions = append(twoCoinbases.Transactions, NewCoinbaseTransaction(to, 1, 1))
+	SignBlock(privKey, twoCoinbases)
+	assert.NotNil(t, chain.AddBlock(twoCoinbases))
+
+	wrongHeight := blockOn(genesis)
+	wrongHeight.Transactions[0] = NewCoinbaseTransaction(to, 2, defaultBlockReward)
+	SignBlock(privKey, wrongHeight)
+	assert.NotNil(t, chain.AddBlock(wrongHeight))
+
+	tooMuch := blockOn(genesis)
+	tooMuch.Transactions[0] = NewCoinbaseTransaction(to, 1, defaultBlockReward+1)
+	SignBlock(privKey, tooMuch)
+	assert.NotNil(t, chain.AddBlock(tooMuch))
+
+	negative := blockOn(genesis)
+	negative.Transactions[0].Outputs = append(negative.Transactions[0].Outputs, &proto.TxOutput{Amount: -1, ToAddress: to})
+	negative.Transactions[0].Outputs[0].Amount++
+	SignBlock(privKey, negative)
+	assert.ErrorIs(t, chain.AddBlock(negative), ErrBadAmount)
+
+	block := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(block))
+	utxo, err := chain.uxtoStore.Get(utxoKey(hex.EncodeToString(HashTransaction(block.Transactions[0])), 0))
+	require.Nil(t, err)
+	assert.Equal(t, int64(defaultBlockReward), utxo.Amount)
+
+	assert.NotNil(t, chain.ValidateTransaction(block.Transactions[0]))
+}
+
+func TestValidateCoinbaseWithFees(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	tx := spendGenesis(chain)
+	tx.Outputs[0].Amount = genesisSupply - 5
+	privKey := Factory{}.CreateGenesisPrivateKey()
+	tx.Inputs[0].Signature = nil
+	tx.Inputs[0].Signature = SignTransaction(privKey, tx).Bytes()
+
+	block := blockOn(genesis, tx)
+	assert.NotNil(t, chain.AddBlock(block))
+
+	block.Transactions[0] = NewCoinbaseTransaction(Factory{}.CreateAddress(), 1, defaultBlockReward+5)
+	SignBlock(privKey, block)
+	assert.Nil(t, chain.AddBlock(block))
+}
+
+func TestOnBlockConnected(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	var connected []string
+	chain.OnBlockConnected(func(b *proto.Block) {
+		connected = append(connected, hex.EncodeToString(HashBlock(b)))
+	})
+
+	a1 := blockOn(genesis)
+	b1 := blockOn(genesis)
+	b2 := blockOn(b1)
+	b3 := blockOn(b2)
+	require.Nil(t, chain.AddBlock(a1))
+	require.Nil(t, chain.AddBlock(b1))
+	assert.ErrorIs(t, chain.AddBlock(b3), ErrOrphanBlock)
+	require.Nil(t, chain.AddBlock(b2))
+
+	expected := []string{}
+	for _, b := range []*proto.Block{a1, b1, b2, b3} {
+		expected = append(expected, hex.EncodeToString(HashBlock(b)))
+	}
+	assert.Equal(t, expected, connected)
+}
+
+func TestFinalize(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	a1 := blockOn(genesis)
+	a2 := blockOn(a1)
+	require.Nil(t, chain.AddBlock(a1))
+	b1 := blockOn(genesis)
+	require.Nil(t, chain.AddBlock(b1))
+	require.Nil(t, chain.AddBlock(a2))
+
+	assert.NotNil(t, chain.Finalize(HashBlock(b1)))
+	require.Nil(t, chain.Finalize(HashBlock(a1)))
+	assert.Equal(t, 1, chain.FinalizedHeight())
+
+	// b1 forks below the finalized block and can never become main chain.
+	b2 := blockOn(b1)
+	b3 := blockOn(b2)
+	require.Nil(t, chain.AddBlock(b2))
+	assert.ErrorIs(t, chain.AddBlock(b3), ErrFinalized)
+	assert.ErrorIs(t, chain.AddBlock(blockOn(genesis)), ErrFinalized)
+	assert.Equal(t, a2.Header, chain.Tip())
+
+	_, err := chain.RollbackTo(0)
+	assert.ErrorIs(t, err, ErrFinalized)
+	_, err = chain.RollbackTo(1)
+	assert.Nil(t, err)
+}
+
+func TestSignVote(t *testing.T) {
+	vote := &proto.Vote{Type: proto.VoteType_PRECOMMIT, Height: 1, Round: 2, BlockHash: util.RandomHash()}
+	SignVote(crypto.GeneratePrivateKey(), vote)
+	assert.True(t, VerifyVote(vote))
+
+	vote.Round = 3
+	assert.False(t, VerifyVote(vote))
 }
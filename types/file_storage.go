@@ -0,0 +1,444 @@
+package types
+
+import (
+	"blocker/proto"
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	pb "google.golang.org/protobuf/proto"
+)
+
+const (
+	storeFileName = "chain.log"
+
+	opPut    byte = 1
+	opDelete byte = 2
+
+	blockPrefix = "block/"
+	undoPrefix  = "undo/"
+	txPrefix    = "tx/"
+	utxoPrefix  = "utxo/"
+	tipKey      = "tip"
+	finalKey    = "finalized"
+)
+
+// recordHeaderLen is the size of the length and checksum that precede
+// every record in the log.
+const recordHeaderLen = 8
+
+type dbOp struct {
+	op    byte
+	key   string
+	value []byte
+}
+
+type dbEntry struct {
+	offset int64
+	length int
+}
+
+// fileDB is an append-only log of key/value records. Every record carries a
+// checksum; on open the log is replayed into an in-memory index of value
+// offsets and a torn record at the end of the file is truncated.
+type fileDB struct {
+	lock  sync.RWMutex
+	file  *os.File
+	size  int64
+	index map[string]dbEntry
+}
+
+func openFileDB(path string) (*fileDB, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	db := &fileDB{
+		file:  f,
+		index: make(map[string]dbEntry),
+	}
+	if err := db.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *fileDB) replay() error {
+	r := bufio.NewReader(db.file)
+	header := make([]byte, recordHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return db.truncate()
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return db.truncate()
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return db.truncate()
+		}
+		ops, err := decodeOps(payload)
+		if err != nil {
+			return db.truncate()
+		}
+		db.apply(db.size+recordHeaderLen, ops)
+		db.size += int64(recordHeaderLen + len(payload))
+	}
+}
+
+// truncate drops everything after the last complete record.
+func (db *fileDB) truncate() error {
+	return db.file.Truncate(db.size)
+}
+
+// write appends ops as a single record, so they are applied all-or-nothing.
+func (db *fileDB) write(ops []dbOp) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	payload := encodeOps(ops)
+	record := make([]byte, recordHeaderLen, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	if _, err := db.file.WriteAt(record, db.size); err != nil {
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		return err
+	}
+	db.apply(db.size+recordHeaderLen, ops)
+	db.size += int64(len(record))
+	return nil
+}
+
+func (db *fileDB) put(key string, value []byte) error {
+	return db.write([]dbOp{{op: opPut, key: key, value: value}})
+}
+
+func (db *fileDB) delete(key string) error {
+	return db.write([]dbOp{{op: opDelete, key: key}})
+}
+
+func (db *fileDB) get(key string) ([]byte, bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	entry, ok := db.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	value := make([]byte, entry.length)
+	if _, err := db.file.ReadAt(value, entry.offset); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (db *fileDB) close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.file.Close()
+}
+
+// apply updates the index for ops whose payload starts at offset.
+func (db *fileDB) apply(offset int64, ops []dbOp) {
+	pos := offset
+	for _, op := range ops {
+		pos += int64(1 + uvarintLen(len(op.key)) + len(op.key))
+		switch op.op {
+		case opPut:
+			pos += int64(uvarintLen(len(op.value)))
+			db.index[op.key] = dbEntry{offset: pos, length: len(op.value)}
+			pos += int64(len(op.value))
+		case opDelete:
+			delete(db.index, op.key)
+		}
+	}
+}
+
+func encodeOps(ops []dbOp) []byte {
+	buf := []byte{}
+	for _, op := range ops {
+		buf = append(buf, op.op)
+		buf = binary.AppendUvarint(buf, uint64(len(op.key)))
+		buf = append(buf, op.key...)
+		if op.op == opPut {
+			buf = binary.AppendUvarint(buf, uint64(len(op.value)))
+			buf = append(buf, op.value...)
+		}
+	}
+	return buf
+}
+
+func decodeOps(buf []byte) ([]dbOp, error) {
+	ops := []dbOp{}
+	for len(buf) > 0 {
+		op := dbOp{op: buf[0]}
+		buf = buf[1:]
+
+		key, rest, err := readBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		op.key, buf = string(key), rest
+
+		switch op.op {
+		case opPut:
+			if op.value, buf, err = readBytes(buf); err != nil {
+				return nil, err
+			}
+		case opDelete:
+		default:
+			return nil, fmt.Errorf("unknown op %d", op.op)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	n, l := binary.Uvarint(buf)
+	if l <= 0 || uint64(len(buf)-l) < n {
+		return nil, nil, errors.New("corrupt record")
+	}
+	return buf[l : l+int(n)], buf[l+int(n):], nil
+}
+
+func uvarintLen(n int) int {
+	return len(binary.AppendUvarint(nil, uint64(n)))
+}
+
+// sharedFileDB returns the log behind the stores if all three are views of
+// the same FileStore.
+func sharedFileDB(bs BlockStorer, ts TXStorer, us UTXOStorer) *fileDB {
+	fb, ok := bs.(*FileBlockStore)
+	if !ok {
+		return nil
+	}
+	ft, ok := ts.(*FileTXStore)
+	if !ok || ft.db != fb.db {
+		return nil
+	}
+	fu, ok := us.(*FileUTXOStore)
+	if !ok || fu.db != fb.db {
+		return nil
+	}
+	return fb.db
+}
+
+func (b *Batch) dbOps() ([]dbOp, error) {
+	ops := make([]dbOp, 0, len(b.ops))
+	for _, op := range b.ops {
+		switch {
+		case op.block != nil:
+			data, err := pb.Marshal(op.block)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, dbOp{op: opPut, key: blockPrefix + hex.EncodeToString(HashBlock(op.block)), value: data})
+		case op.undo != nil:
+			data, err := json.Marshal(op.undo)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, dbOp{op: opPut, key: undoPrefix + op.hash, value: data})
+		case op.tip != "":
+			ops = append(ops, dbOp{op: opPut, key: tipKey, value: []byte(op.tip)})
+		case op.tx != nil:
+			data, err := pb.Marshal(op.tx)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, dbOp{op: opPut, key: txPrefix + hex.EncodeToString(HashTransaction(op.tx)), value: data})
+		case op.utxo != nil:
+			data, err := json.Marshal(op.utxo)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, dbOp{op: opPut, key: utxoPrefix + utxoKey(op.utxo.Hash, op.utxo.OutIndex), value: data})
+		case op.delete != "":
+			ops = append(ops, dbOp{op: opDelete, key: utxoPrefix + op.delete})
+		}
+	}
+	return ops, nil
+}
+
+// FileStore keeps blocks, transactions and UTXOs in a single append-only log
+// inside a data directory. Use BlockStore, TXStore and UTXOStore to get the
+// views a Chain needs.
+type FileStore struct {
+	db *fileDB
+}
+
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	db, err := openFileDB(filepath.Join(dir, storeFileName))
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{db: db}, nil
+}
+
+func (s *FileStore) BlockStore() *FileBlockStore {
+	return &FileBlockStore{db: s.db}
+}
+
+func (s *FileStore) TXStore() *FileTXStore {
+	return &FileTXStore{db: s.db}
+}
+
+func (s *FileStore) UTXOStore() *FileUTXOStore {
+	return &FileUTXOStore{db: s.db}
+}
+
+func (s *FileStore) Close() error {
+	return s.db.close()
+}
+
+type FileBlockStore struct {
+	db *fileDB
+}
+
+func (s *FileBlockStore) Put(b *proto.Block) error {
+	data, err := pb.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return s.db.put(blockPrefix+hex.EncodeToString(HashBlock(b)), data)
+}
+
+func (s *FileBlockStore) Get(hash string) (*proto.Block, error) {
+	data, ok, err := s.db.get(blockPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("block with hash [%s] not found", hash)
+	}
+	block := &proto.Block{}
+	if err := pb.Unmarshal(data, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *FileBlockStore) PutTip(hash string) error {
+	return s.db.put(tipKey, []byte(hash))
+}
+
+func (s *FileBlockStore) GetTip() (string, error) {
+	data, ok, err := s.db.get(tipKey)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrNoTip
+	}
+	return string(data), nil
+}
+
+func (s *FileBlockStore) PutFinalized(hash string) error {
+	return s.db.put(finalKey, []byte(hash))
+}
+
+func (s *FileBlockStore) GetFinalized() (string, error) {
+	data, _, err := s.db.get(finalKey)
+	return string(data), err
+}
+
+func (s *FileBlockStore) PutUndo(hash string, undo *BlockUndo) error {
+	data, err := json.Marshal(undo)
+	if err != nil {
+		return err
+	}
+	return s.db.put(undoPrefix+hash, data)
+}
+
+func (s *FileBlockStore) GetUndo(hash string) (*BlockUndo, error) {
+	data, ok, err := s.db.get(undoPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("undo data for block [%s] not found", hash)
+	}
+	undo := &BlockUndo{}
+	if err := json.Unmarshal(data, undo); err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
+
+type FileTXStore struct {
+	db *fileDB
+}
+
+func (s *FileTXStore) Put(tx *proto.Transaction) error {
+	data, err := pb.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	return s.db.put(txPrefix+hex.EncodeToString(HashTransaction(tx)), data)
+}
+
+func (s *FileTXStore) Get(hash string) (*proto.Transaction, error) {
+	data, ok, err := s.db.get(txPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("could not find tx with hash %s", hash)
+	}
+	tx := &proto.Transaction{}
+	if err := pb.Unmarshal(data, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+type FileUTXOStore struct {
+	db *fileDB
+}
+
+func (s *FileUTXOStore) Put(utxo *UTXO) error {
+	data, err := json.Marshal(utxo)
+	if err != nil {
+		return err
+	}
+	return s.db.put(utxoPrefix+utxoKey(utxo.Hash, utxo.OutIndex), data)
+}
+
+func (s *FileUTXOStore) Get(hash string) (*UTXO, error) {
+	data, ok, err := s.db.get(utxoPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("could not find UXTO")
+	}
+	utxo := &UTXO{}
+	if err := json.Unmarshal(data, utxo); err != nil {
+		return nil, err
+	}
+	return utxo, nil
+}
+
+func (s *FileUTXOStore) Delete(hash string) error {
+	return s.db.delete(utxoPrefix + hash)
+}
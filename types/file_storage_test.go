@@ -0,0 +1,180 @@
+package types
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorePutGet(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir())
+	require.Nil(t, err)
+	defer store.Close()
+
+	block := createGenesisBlock()
+	hash := hex.EncodeToString(HashBlock(block))
+	require.Nil(t, store.BlockStore().Put(block))
+	actualBlock, err := store.BlockStore().Get(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, HashBlock(block), HashBlock(actualBlock))
+
+	tx := block.Transactions[0]
+	txHash := hex.EncodeToString(HashTransaction(tx))
+	require.Nil(t, store.TXStore().Put(tx))
+	actualTx, err := store.TXStore().Get(txHash)
+	assert.Nil(t, err)
+	assert.Equal(t, HashTransaction(tx), HashTransaction(actualTx))
+
+	utxo := &UTXO{Hash: txHash, OutIndex: 0, Amount: 10}
+	require.Nil(t, store.UTXOStore().Put(utxo))
+	actualUTXO, err := store.UTXOStore().Get(utxoKey(txHash, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, utxo, actualUTXO)
+
+	require.Nil(t, store.UTXOStore().Delete(utxoKey(txHash, 0)))
+	_, err = store.UTXOStore().Get(utxoKey(txHash, 0))
+	assert.NotNil(t, err)
+
+	_, err = store.BlockStore().GetTip()
+	assert.ErrorIs(t, err, ErrNoTip)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	require.Nil(t, err)
+
+	utxo := &UTXO{Hash: "aa", OutIndex: 1, Amount: 10}
+	require.Nil(t, store.UTXOStore().Put(utxo))
+	utxo.Spent = true
+	require.Nil(t, store.UTXOStore().Put(utxo))
+	require.Nil(t, store.UTXOStore().Put(&UTXO{Hash: "bb", OutIndex: 0, Amount: 5}))
+	require.Nil(t, store.UTXOStore().Delete("bb_0"))
+	require.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir)
+	require.Nil(t, err)
+	defer store.Close()
+
+	actual, err := store.UTXOStore().Get("aa_1")
+	assert.Nil(t, err)
+	assert.True(t, actual.Spent)
+	_, err = store.UTXOStore().Get("bb_0")
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreTruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	require.Nil(t, err)
+	require.Nil(t, store.UTXOStore().Put(&UTXO{Hash: "aa", OutIndex: 0, Amount: 10}))
+	require.Nil(t, store.Close())
+
+	path := filepath.Join(dir, storeFileName)
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.Nil(t, err)
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	store, err = OpenFileStore(dir)
+	require.Nil(t, err)
+	defer store.Close()
+
+	_, err = store.UTXOStore().Get("aa_0")
+	assert.Nil(t, err)
+	info2, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, info.Size(), info2.Size())
+}
+
+func TestOpenChainFromFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	require.Nil(t, err)
+	chain, err := OpenChain(store.BlockStore(), store.TXStore(), store.UTXOStore())
+	require.Nil(t, err)
+
+	tx := spendGenesis(chain)
+	genesis, _ := chain.GetBlockByHeight(0)
+	require.Nil(t, chain.AddBlock(blockOn(genesis, tx)))
+	for i := 0; i < 5; i++ {
+		require.Nil(t, chain.AddBlock(randomBlock(chain)))
+	}
+	tip := chain.Tip()
+	require.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir)
+	require.Nil(t, err)
+	defer store.Close()
+	chain, err = OpenChain(store.BlockStore(), store.TXStore(), store.UTXOStore())
+	require.Nil(t, err)
+
+	assert.Equal(t, 6, chain.Height())
+	assert.Equal(t, HashHeader(tip), HashHeader(chain.Tip()))
+	utxo, err := chain.uxtoStore.Get(utxoKey(hex.EncodeToString(HashTransaction(tx)), 0))
+	assert.Nil(t, err)
+	assert.False(t, utxo.Spent)
+
+	require.Nil(t, chain.AddBlock(randomBlock(chain)))
+	assert.Equal(t, 7, chain.Height())
+}
+
+func TestOpenChainKeepsFinality(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	require.Nil(t, err)
+	chain, err := OpenChain(store.BlockStore(), store.TXStore(), store.UTXOStore())
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, chain.AddBlock(randomBlock(chain)))
+	}
+	finalized, _ := chain.GetBlockByHeight(2)
+	require.Nil(t, chain.Finalize(HashBlock(finalized)))
+	require.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir)
+	require.Nil(t, err)
+	defer store.Close()
+	chain, err = OpenChain(store.BlockStore(), store.TXStore(), store.UTXOStore())
+	require.Nil(t, err)
+
+	assert.Equal(t, 2, chain.FinalizedHeight())
+	_, err = chain.RollbackTo(1)
+	assert.ErrorIs(t, err, ErrFinalized)
+}
+
+func TestFileStoreBatchIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileStore(dir)
+	require.Nil(t, err)
+
+	batch := NewBatch(store.BlockStore(), store.TXStore(), store.UTXOStore())
+	batch.PutUTXO(&UTXO{Hash: "aa", OutIndex: 0, Amount: 10})
+	batch.PutUTXO(&UTXO{Hash: "bb", OutIndex: 0, Amount: 20})
+	batch.PutTip("cc")
+	require.Nil(t, batch.Commit())
+	require.Nil(t, store.Close())
+
+	// cut the single record short as if the write was interrupted
+	path := filepath.Join(dir, storeFileName)
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Nil(t, os.Truncate(path, info.Size()-3))
+
+	store, err = OpenFileStore(dir)
+	require.Nil(t, err)
+	defer store.Close()
+
+	_, err = store.UTXOStore().Get("aa_0")
+	assert.NotNil(t, err)
+	_, err = store.UTXOStore().Get("bb_0")
+	assert.NotNil(t, err)
+	_, err = store.BlockStore().GetTip()
+	assert.ErrorIs(t, err, ErrNoTip)
+}
@@ -0,0 +1,77 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	pb "google.golang.org/protobuf/proto"
+)
+
+var ErrFinalized = errors.New("conflicts with a finalized block")
+
+// HashVote returns the hash a vote's signature covers, which is the vote
+// without its signature.
+func HashVote(v *proto.Vote) []byte {
+	unsigned := pb.Clone(v).(*proto.Vote)
+	unsigned.Signature = nil
+	b, err := pb.Marshal(unsigned)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(b)
+	return hash[:]
+}
+
+func SignVote(pk *crypto.PrivateKey, v *proto.Vote) {
+	v.PublicKey = pk.Public().Bytes()
+	v.Signature = pk.Sign(HashVote(v)).Bytes()
+}
+
+func VerifyVote(v *proto.Vote) bool {
+	if len(v.Signature) != crypto.SigLen || len(v.PublicKey) != crypto.PubKeyLen {
+		return false
+	}
+	sig := crypto.SignatureFromBytes(v.Signature)
+	return sig.Verify(crypto.PublicKeyFromBytes(v.PublicKey), HashVote(v))
+}
+
+// Finalize marks the block with the given hash and all its ancestors as
+// final. The block has to be on the main chain. The chain never disconnects
+// a finalized block, neither in a reorg nor in RollbackTo, and the block
+// store keeps the finalized block across restarts.
+func (c *Chain) Finalize(hash []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	node, ok := c.index[hex.EncodeToString(hash)]
+	if !ok || !c.isMainChain(node) {
+		return fmt.Errorf("block %s is not on the main chain", hex.EncodeToString(hash))
+	}
+	if node.height <= c.finalizedHeight() {
+		return nil
+	}
+	if err := c.blockStore.PutFinalized(node.hash); err != nil {
+		return err
+	}
+	c.finalized = node
+	return nil
+}
+
+// FinalizedHeight returns the height of the last finalized block. The
+// genesis block is always final.
+func (c *Chain) FinalizedHeight() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.finalizedHeight()
+}
+
+func (c *Chain) finalizedHeight() int {
+	if c.finalized == nil {
+		return 0
+	}
+	return c.finalized.height
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"blocker/proto"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// blockVersion is the only header version this chain accepts.
+	blockVersion = 1
+	// medianTimeBlocks is the number of blocks whose median timestamp a new
+	// block has to be after.
+	medianTimeBlocks = 11
+	// maxFutureBlockTime is how far ahead of the local clock a block
+	// timestamp may be.
+	maxFutureBlockTime = 2 * time.Minute
+)
+
+var (
+	ErrBadVersion      = errors.New("unsupported block version")
+	ErrBadHeight       = errors.New("block height does not follow its parent")
+	ErrTimestampTooOld = errors.New("block timestamp not after the median of recent blocks")
+	ErrTimestampTooNew = errors.New("block timestamp too far in the future")
+)
+
+// medianTime returns the median timestamp of node and the blocks before it,
+// up to medianTimeBlocks of them.
+func medianTime(node *blockNode) int64 {
+	timestamps := []int64{}
+	for ; node != nil && len(timestamps) < medianTimeBlocks; node = node.parent {
+		timestamps = append(timestamps, node.header.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// validateHeader checks the header of b, the child of parent: a supported
+// version, the height after its parent and a timestamp after the median of
+// the recent blocks and not too far ahead of the local clock.
+func validateHeader(b *proto.Block, parent *blockNode) error {
+	header := b.Header
+	if header.Version != blockVersion {
+		return fmt.Errorf("%w: %d", ErrBadVersion, header.Version)
+	}
+	if int(header.Height) != parent.height+1 {
+		return fmt.Errorf("%w: height %d, parent height %d", ErrBadHeight, header.Height, parent.height)
+	}
+	if median := medianTime(parent); header.Timestamp <= median {
+		return fmt.Errorf("%w: timestamp %d, median %d", ErrTimestampTooOld, header.Timestamp, median)
+	}
+	if limit := time.Now().Add(maxFutureBlockTime).UnixNano(); header.Timestamp > limit {
+		return fmt.Errorf("%w: timestamp %d, limit %d", ErrTimestampTooNew, header.Timestamp, limit)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHeader(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	blockAt := func(parent *proto.Block, timestamp int64, edit func(*proto.Header)) *proto.Block {
+		b := blockOn(parent)
+		b.Header.Timestamp = timestamp
+		if edit != nil {
+			edit(b.Header)
+		}
+		SignBlock(crypto.GeneratePrivateKey(), b)
+		return b
+	}
+
+	parent := genesis
+	for i := int64(1); i <= 3; i++ {
+		b := blockAt(parent, i*10, nil)
+		require.Nil(t, chain.AddBlock(b))
+		parent = b
+	}
+
+	assert.ErrorIs(t, chain.AddBlock(blockAt(parent, 40, func(h *proto.Header) { h.Version = 2 })), ErrBadVersion)
+	assert.ErrorIs(t, chain.AddBlock(blockAt(parent, 40, func(h *proto.Header) { h.Height += 5 })), ErrBadHeight)
+	// The median of 0, 10, 20 and 30 is 20.
+	assert.ErrorIs(t, chain.AddBlock(blockAt(parent, 20, nil)), ErrTimestampTooOld)
+	assert.ErrorIs(t, chain.AddBlock(blockAt(parent, time.Now().Add(time.Hour).UnixNano(), nil)), ErrTimestampTooNew)
+	require.Nil(t, chain.AddBlock(blockAt(parent, 21, nil)))
+
+	// Blocks on a side branch are checked against their own parent.
+	assert.ErrorIs(t, chain.AddBlock(blockAt(genesis, 5, func(h *proto.Header) { h.Height = 7 })), ErrBadHeight)
+}
@@ -0,0 +1,157 @@
+package types
+
+import (
+	"blocker/proto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	maxOrphanBlocks = 100
+	maxOrphanAge    = time.Minute * 10
+)
+
+var (
+	ErrOrphanBlock = errors.New("orphan block")
+	ErrKnownBlock  = errors.New("block already known")
+)
+
+// checkOrphan checks what can be checked of a block without its parent
+// before it takes a slot in the orphan pool: its signature, a height above
+// the finalized block and within reach of the orphan pool from the tip, and
+// a timestamp not too far ahead of the local clock.
+func (c *Chain) checkOrphan(b *proto.Block) error {
+	verified, err := VerifyBlock(b)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("unable to verify block")
+	}
+	height := int(b.Header.Height)
+	if height <= c.finalizedHeight()+1 || height > c.height()+maxOrphanBlocks {
+		return fmt.Errorf("%w: orphan at height %d, tip at height %d", ErrBadHeight, height, c.height())
+	}
+	if limit := time.Now().Add(maxFutureBlockTime).UnixNano(); b.Header.Timestamp > limit {
+		return fmt.Errorf("%w: timestamp %d, limit %d", ErrTimestampTooNew, b.Header.Timestamp, limit)
+	}
+	return nil
+}
+
+type orphan struct {
+	block *proto.Block
+	added time.Time
+}
+
+// OrphanPool holds blocks whose parent is not known yet, keyed by the hash
+// of the missing parent. The pool is bounded in size and age; when full the
+// oldest orphan is evicted.
+type OrphanPool struct {
+	lock     sync.Mutex
+	maxSize  int
+	maxAge   time.Duration
+	orphans  map[string]*orphan
+	byParent map[string][]string
+}
+
+func NewOrphanPool(maxSize int, maxAge time.Duration) *OrphanPool {
+	return &OrphanPool{
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		orphans:  make(map[string]*orphan),
+		byParent: make(map[string][]string),
+	}
+}
+
+func (p *OrphanPool) Has(hash string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, ok := p.orphans[hash]
+	return ok
+}
+
+// Add stores b until its parent arrives. It returns false if b is already
+// in the pool.
+func (p *OrphanPool) Add(b *proto.Block) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hash := hex.EncodeToString(HashBlock(b))
+	if _, ok := p.orphans[hash]; ok {
+		return false
+	}
+	p.expire()
+	for len(p.orphans) >= p.maxSize {
+		p.evictOldest()
+	}
+
+	parent := hex.EncodeToString(b.Header.PreviousHash)
+	p.orphans[hash] = &orphan{block: b, added: time.Now()}
+	p.byParent[parent] = append(p.byParent[parent], hash)
+	return true
+}
+
+// TakeChildren removes and returns the orphans waiting for parentHash.
+func (p *OrphanPool) TakeChildren(parentHash string) []*proto.Block {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	blocks := []*proto.Block{}
+	for _, hash := range p.byParent[parentHash] {
+		if o, ok := p.orphans[hash]; ok {
+			blocks = append(blocks, o.block)
+			delete(p.orphans, hash)
+		}
+	}
+	delete(p.byParent, parentHash)
+	return blocks
+}
+
+func (p *OrphanPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.orphans)
+}
+
+func (p *OrphanPool) expire() {
+	for hash, o := range p.orphans {
+		if time.Since(o.added) > p.maxAge {
+			p.remove(hash)
+		}
+	}
+}
+
+func (p *OrphanPool) evictOldest() {
+	var oldest string
+	for hash, o := range p.orphans {
+		if oldest == "" || o.added.Before(p.orphans[oldest].added) {
+			oldest = hash
+		}
+	}
+	p.remove(oldest)
+}
+
+func (p *OrphanPool) remove(hash string) {
+	o, ok := p.orphans[hash]
+	if !ok {
+		return
+	}
+	delete(p.orphans, hash)
+
+	parent := hex.EncodeToString(o.block.Header.PreviousHash)
+	siblings := p.byParent[parent]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, parent)
+	} else {
+		p.byParent[parent] = siblings
+	}
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/util"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanPoolTakeChildren(t *testing.T) {
+	pool := NewOrphanPool(10, time.Minute)
+	block := util.RandomBlock()
+	SignBlock(crypto.GeneratePrivateKey(), block)
+
+	assert.True(t, pool.Add(block))
+	assert.False(t, pool.Add(block))
+	assert.True(t, pool.Has(hex.EncodeToString(HashBlock(block))))
+	assert.Equal(t, 1, pool.Len())
+
+	assert.Empty(t, pool.TakeChildren(hex.EncodeToString(util.RandomHash())))
+
+	children := pool.TakeChildren(hex.EncodeToString(block.Header.PreviousHash))
+	assert.Equal(t, 1, len(children))
+	assert.Equal(t, block, children[0])
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestOrphanPoolEvictsOldest(t *testing.T) {
+	pool := NewOrphanPool(2, time.Minute)
+	first := util.RandomBlock()
+	pool.Add(first)
+	time.Sleep(time.Millisecond)
+	pool.Add(util.RandomBlock())
+	pool.Add(util.RandomBlock())
+
+	assert.Equal(t, 2, pool.Len())
+	assert.False(t, pool.Has(hex.EncodeToString(HashBlock(first))))
+}
+
+func TestOrphanPoolExpires(t *testing.T) {
+	pool := NewOrphanPool(10, time.Millisecond)
+	stale := util.RandomBlock()
+	pool.Add(stale)
+	time.Sleep(time.Millisecond * 5)
+	pool.Add(util.RandomBlock())
+
+	assert.Equal(t, 1, pool.Len())
+	assert.False(t, pool.Has(hex.EncodeToString(HashBlock(stale))))
+}
@@ -0,0 +1,174 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultBlockReward  = 10
+	defaultSlotDuration = time.Second * 5
+)
+
+var (
+	ErrWrongLeader = errors.New("block not signed by the slot leader")
+	ErrSlotReused  = errors.New("block slot not after its parent's slot")
+)
+
+// Params are the consensus rules every node of a network has to agree on.
+type Params struct {
+	// BlockReward is the amount a coinbase transaction mints on top of the
+	// fees of the block it belongs to.
+	BlockReward int64
+	// SlotDuration divides time into slots, each of which may hold at most
+	// one block.
+	SlotDuration time.Duration
+	// Validators are the genesis validators. They produce blocks, taking
+	// turns slot by slot, in every epoch that starts without any bonded
+	// stake; when a leader's slot passes without a block the next leader
+	// simply builds on the last block. Without stake and genesis validators
+	// any key may produce blocks.
+	Validators [][]byte
+	// EpochLength is the number of blocks between changes of the validator
+	// set. The set of an epoch is derived from the stake bonded before it.
+	EpochLength int
+	// UnbondingPeriod is the number of blocks the outputs of an unstake
+	// transaction stay locked.
+	UnbondingPeriod int
+
+	// ProofOfWork replaces the validators by miners. Blocks have to meet the
+	// target in their bits and SlotDuration is the targeted time between
+	// blocks.
+	ProofOfWork bool
+	// InitialBits is the target of the first blocks and the easiest target
+	// retargeting may reach.
+	InitialBits uint32
+	// RetargetInterval is the number of blocks between difficulty
+	// adjustments.
+	RetargetInterval int
+}
+
+func DefaultParams() Params {
+	return Params{
+		BlockReward:      defaultBlockReward,
+		SlotDuration:     defaultSlotDuration,
+		InitialBits:      defaultInitialBits,
+		RetargetInterval: defaultRetargetInterval,
+		EpochLength:      defaultEpochLength,
+		UnbondingPeriod:  defaultUnbondingPeriod,
+	}
+}
+
+// paramsFile is the JSON layout of a consensus parameters file:
+//
+//	{
+//	  "blockReward": 10,
+//	  "slotDuration": "5s",
+//	  "validators": ["<hex public key>", ...],
+//	  "epochLength": 10,
+//	  "unbondingPeriod": 20,
+//	  "proofOfWork": false,
+//	  "initialBits": 520159231,
+//	  "retargetInterval": 10
+//	}
+//
+// Omitted fields keep their defaults.
+type paramsFile struct {
+	BlockReward      int64    `json:"blockReward"`
+	SlotDuration     string   `json:"slotDuration"`
+	Validators       []string `json:"validators"`
+	EpochLength      int      `json:"epochLength"`
+	UnbondingPeriod  int      `json:"unbondingPeriod"`
+	ProofOfWork      bool     `json:"proofOfWork"`
+	InitialBits      uint32   `json:"initialBits"`
+	RetargetInterval int      `json:"retargetInterval"`
+}
+
+// LoadParams reads the consensus parameters from the JSON file at path.
+func LoadParams(path string) (Params, error) {
+	p := DefaultParams()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	var f paramsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return p, fmt.Errorf("invalid params file %s: %w", path, err)
+	}
+
+	if f.BlockReward < 0 {
+		return p, fmt.Errorf("block reward must be positive, got %d", f.BlockReward)
+	}
+	if f.BlockReward != 0 {
+		p.BlockReward = f.BlockReward
+	}
+	if f.SlotDuration != "" {
+		d, err := time.ParseDuration(f.SlotDuration)
+		if err != nil {
+			return p, fmt.Errorf("invalid slot duration %q: %w", f.SlotDuration, err)
+		}
+		if d <= 0 {
+			return p, fmt.Errorf("slot duration must be positive, got %s", d)
+		}
+		p.SlotDuration = d
+	}
+	for _, v := range f.Validators {
+		key, err := hex.DecodeString(v)
+		if err != nil || len(key) != crypto.PubKeyLen {
+			return p, fmt.Errorf("invalid validator public key %q", v)
+		}
+		p.Validators = append(p.Validators, key)
+	}
+	if f.EpochLength != 0 {
+		p.EpochLength = f.EpochLength
+	}
+	if f.UnbondingPeriod != 0 {
+		p.UnbondingPeriod = f.UnbondingPeriod
+	}
+	if p.EpochLength <= 0 || p.UnbondingPeriod <= 0 {
+		return p, fmt.Errorf("epoch length and unbonding period must be positive, got %d and %d", p.EpochLength, p.UnbondingPeriod)
+	}
+	p.ProofOfWork = f.ProofOfWork
+	if f.InitialBits != 0 {
+		p.InitialBits = f.InitialBits
+	}
+	if f.RetargetInterval != 0 {
+		p.RetargetInterval = f.RetargetInterval
+	}
+	if p.ProofOfWork && len(p.Validators) > 0 {
+		return p, fmt.Errorf("proof of work and a validator set are mutually exclusive")
+	}
+	if p.RetargetInterval <= 0 {
+		return p, fmt.Errorf("retarget interval must be positive, got %d", p.RetargetInterval)
+	}
+	return p, nil
+}
+
+// Slot returns the slot a block with the given timestamp in nanoseconds
+// belongs to.
+func (p Params) Slot(timestamp int64) int64 {
+	return timestamp / int64(p.SlotDuration)
+}
+
+// validateLeader checks that b was produced by the leader of its slot in
+// set and in a later slot than its parent.
+func (p Params) validateLeader(set *ValidatorSet, b *proto.Block, parent *proto.Header) error {
+	if set.Open() {
+		return nil
+	}
+	slot := p.Slot(b.Header.Timestamp)
+	if parentSlot := p.Slot(parent.Timestamp); slot <= parentSlot {
+		return fmt.Errorf("%w: slot %d, parent slot %d", ErrSlotReused, slot, parentSlot)
+	}
+	if leader := set.Leader(slot); !bytes.Equal(b.PublicKey, leader) {
+		return fmt.Errorf("%w: slot %d signed by %s, leader is %s", ErrWrongLeader, slot, hex.EncodeToString(b.PublicKey), hex.EncodeToString(leader))
+	}
+	return nil
+}
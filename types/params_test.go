@@ -0,0 +1,83 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadParams(t *testing.T) {
+	key := crypto.GeneratePrivateKey().Public().Bytes()
+	path := filepath.Join(t.TempDir(), "params.json")
+	data := `{"slotDuration": "2s", "validators": ["` + hex.EncodeToString(key) + `"]}`
+	require.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+
+	params, err := LoadParams(path)
+	require.Nil(t, err)
+	assert.Equal(t, int64(defaultBlockReward), params.BlockReward)
+	assert.Equal(t, 2*time.Second, params.SlotDuration)
+	assert.Equal(t, [][]byte{key}, params.Validators)
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"validators": ["abcd"]}`), 0o644))
+	_, err = LoadParams(path)
+	assert.NotNil(t, err)
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"blockReward": -1}`), 0o644))
+	_, err = LoadParams(path)
+	assert.NotNil(t, err)
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"proofOfWork": true, "initialBits": 536936447}`), 0o644))
+	params, err = LoadParams(path)
+	require.Nil(t, err)
+	assert.True(t, params.ProofOfWork)
+	assert.Equal(t, uint32(0x2000ffff), params.InitialBits)
+	assert.Equal(t, defaultRetargetInterval, params.RetargetInterval)
+
+	data = `{"proofOfWork": true, "validators": ["` + hex.EncodeToString(key) + `"]}`
+	require.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+	_, err = LoadParams(path)
+	assert.NotNil(t, err)
+}
+
+func TestValidateSlotLeader(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	first, second := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+
+	params := chain.Params()
+	params.SlotDuration = time.Second
+	params.Validators = [][]byte{first.Public().Bytes(), second.Public().Bytes()}
+	chain.SetParams(params)
+
+	blockInSlot := func(parent *proto.Block, key *crypto.PrivateKey, slot int64) *proto.Block {
+		b := blockOn(parent)
+		b.Header.Timestamp = slot * int64(time.Second)
+		SignBlock(key, b)
+		return b
+	}
+
+	assert.ErrorIs(t, chain.AddBlock(blockInSlot(genesis, first, 3)), ErrWrongLeader)
+	b1 := blockInSlot(genesis, second, 3)
+	require.Nil(t, chain.AddBlock(b1))
+
+	assert.ErrorIs(t, chain.AddBlock(blockInSlot(b1, second, 3)), ErrSlotReused)
+	// The leaders of slots 4 and 5 missed their turn.
+	require.Nil(t, chain.AddBlock(blockInSlot(b1, first, 6)))
+	assert.Equal(t, 2, chain.Height())
+
+	// Blocks on a side branch need the slot leader's signature as well.
+	rogue := blockInSlot(genesis, first, 5)
+	assert.ErrorIs(t, chain.AddBlock(rogue), ErrWrongLeader)
+	assert.False(t, chain.HasBlock(HashBlock(rogue)))
+	side := blockInSlot(genesis, second, 5)
+	require.Nil(t, chain.AddBlock(side))
+	assert.True(t, chain.HasBlock(HashBlock(side)))
+	assert.Equal(t, 2, chain.Height())
+}
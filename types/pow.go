@@ -0,0 +1,171 @@
+package types
+
+import (
+	"blocker/proto"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// defaultInitialBits is a target of about 2^240, so a block takes about
+	// 2^16 hashes.
+	defaultInitialBits      = 0x1f00ffff
+	defaultRetargetInterval = 10
+)
+
+var (
+	ErrBadDifficulty    = errors.New("block has the wrong difficulty")
+	ErrInsufficientWork = errors.New("block hash does not meet its target")
+)
+
+// CompactToBig expands a target in the compact form of Header.Bits. The top
+// byte is the length of the target in bytes and the lower three bytes are
+// its most significant bytes.
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+	n := big.NewInt(mantissa)
+	return n.Lsh(n, 8*(exponent-3))
+}
+
+// BigToCompact is the inverse of CompactToBig, dropping the precision the
+// compact form cannot hold.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() <= 0 {
+		return 0
+	}
+	exponent := uint(len(n.Bytes()))
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(n.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(n, 8*(exponent-3)).Uint64())
+	}
+	// The high bit of the mantissa is a sign bit, keep it clear.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+// blockWork returns the expected number of hashes needed to find a block
+// with the given bits. Without proof of work every block counts as one unit
+// of work whatever its header says, which makes the most work chain the
+// longest chain.
+func (c *Chain) blockWork(bits uint32) *big.Int {
+	if !c.params.ProofOfWork || bits == 0 {
+		return big.NewInt(1)
+	}
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// reindexWork recomputes the total work of every indexed block, which
+// depends on whether proof of work is on.
+func (c *Chain) reindexWork() {
+	done := make(map[*blockNode]bool, len(c.index))
+	for _, node := range c.index {
+		path := []*blockNode{}
+		for n := node; n != nil && !done[n]; n = n.parent {
+			path = append(path, n)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			n := path[i]
+			n.work = c.blockWork(n.header.Bits)
+			if n.parent != nil {
+				n.work.Add(n.work, n.parent.work)
+			}
+			done[n] = true
+		}
+	}
+}
+
+// CheckProofOfWork reports whether the hash of header meets the target in
+// its bits.
+func CheckProofOfWork(header *proto.Header) bool {
+	target := CompactToBig(header.Bits)
+	return target.Sign() > 0 && new(big.Int).SetBytes(HashHeader(header)).Cmp(target) <= 0
+}
+
+// NextBits returns the bits a block on top of the current tip must carry.
+func (c *Chain) NextBits() uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.nextBits(c.tip())
+}
+
+// nextBits returns the bits of the block after parent. Every
+// RetargetInterval blocks the target is scaled by how long the last
+// RetargetInterval blocks took compared to SlotDuration per block, by at most
+// a factor of four, and never gets easier than InitialBits. Blocks without
+// proof of work carry no bits.
+func (c *Chain) nextBits(parent *blockNode) uint32 {
+	p := c.params
+	if !p.ProofOfWork {
+		return 0
+	}
+	if parent.height == 0 {
+		return p.InitialBits
+	}
+	height := parent.height + 1
+	if height%p.RetargetInterval != 0 {
+		return parent.header.Bits
+	}
+
+	// The timespan from first to parent covers one block interval per step.
+	// The genesis block has no meaningful timestamp, so the first window
+	// starts at height 1 and has fewer than RetargetInterval steps.
+	first, intervals := parent, 0
+	for intervals < p.RetargetInterval && first.parent != nil && first.parent.height > 0 {
+		first = first.parent
+		intervals++
+	}
+	if intervals == 0 {
+		return parent.header.Bits
+	}
+	expected := int64(intervals) * int64(p.SlotDuration)
+	actual := min(max(parent.header.Timestamp-first.header.Timestamp, expected/4), expected*4)
+
+	target := CompactToBig(parent.header.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if limit := CompactToBig(p.InitialBits); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return BigToCompact(target)
+}
+
+// validateWork checks that b, the child of parent, carries the expected
+// difficulty and, with proof of work, that its hash meets it. Without proof
+// of work the bits have to be zero.
+func (c *Chain) validateWork(b *proto.Block, parent *blockNode) error {
+	if expected := c.nextBits(parent); b.Header.Bits != expected {
+		return fmt.Errorf("%w: bits %08x, expected %08x", ErrBadDifficulty, b.Header.Bits, expected)
+	}
+	if c.params.ProofOfWork && !CheckProofOfWork(b.Header) {
+		return ErrInsufficientWork
+	}
+	return nil
+}
+
+// Mine searches up to tries nonces, starting at the header's current one,
+// for a nonce that makes the hash of header meet its target. It reports
+// whether it found one; the header keeps the last nonce tried.
+func Mine(header *proto.Header, tries uint64) bool {
+	for i := uint64(0); i < tries; i++ {
+		if CheckProofOfWork(header) {
+			return true
+		}
+		header.Nonce++
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// easyBits is a target of about 2^248, one in 256 hashes meets it.
+const easyBits = 0x2000ffff
+
+func powChain() (*Chain, *proto.Block) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	params := chain.Params()
+	params.ProofOfWork = true
+	params.InitialBits = easyBits
+	params.RetargetInterval = 2
+	chain.SetParams(params)
+	genesis, _ := chain.GetBlockByHeight(0)
+	return chain, genesis
+}
+
+func minedBlockOn(parent *proto.Block, bits uint32, timestamp int64) *proto.Block {
+	b := blockOn(parent)
+	b.Header.Bits = bits
+	b.Header.Timestamp = timestamp
+	Mine(b.Header, 1<<20)
+	SignBlock(crypto.GeneratePrivateKey(), b)
+	return b
+}
+
+func TestCompactToBig(t *testing.T) {
+	target := CompactToBig(0x1d00ffff)
+	assert.Equal(t, new(big.Int).Lsh(big.NewInt(0xffff), 208), target)
+	assert.Equal(t, uint32(0x1d00ffff), BigToCompact(target))
+	assert.Equal(t, uint32(easyBits), BigToCompact(CompactToBig(easyBits)))
+	// 0x80 would set the sign bit of the mantissa.
+	assert.Equal(t, uint32(0x03008000), BigToCompact(big.NewInt(0x8000)))
+
+	chain, _ := powChain()
+	assert.Equal(t, big.NewInt(1), chain.blockWork(0))
+	assert.Equal(t, 1, chain.blockWork(0x1d00ffff).Cmp(chain.blockWork(easyBits)))
+}
+
+func TestValidateWork(t *testing.T) {
+	chain, genesis := powChain()
+	assert.Equal(t, uint32(easyBits), chain.NextBits())
+
+	b := blockOn(genesis)
+	b.Header.Bits = easyBits
+	for CheckProofOfWork(b.Header) {
+		b.Header.Nonce++
+	}
+	SignBlock(crypto.GeneratePrivateKey(), b)
+	assert.ErrorIs(t, chain.AddBlock(b), ErrInsufficientWork)
+
+	assert.ErrorIs(t, chain.AddBlock(minedBlockOn(genesis, 0x207fffff, genesis.Header.Timestamp+1)), ErrBadDifficulty)
+	require.Nil(t, chain.AddBlock(minedBlockOn(genesis, easyBits, genesis.Header.Timestamp+1)))
+	assert.Equal(t, 1, chain.Height())
+}
+
+func TestBitsWithoutProofOfWork(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	parent := genesis
+	for i := 0; i < 3; i++ {
+		b := blockOn(parent)
+		require.Nil(t, chain.AddBlock(b))
+		parent = b
+	}
+
+	// Claimed work must not let a short branch take over.
+	b := blockOn(genesis)
+	b.Header.Bits = 0x03000001
+	SignBlock(crypto.GeneratePrivateKey(), b)
+	assert.ErrorIs(t, chain.AddBlock(b), ErrBadDifficulty)
+	assert.Equal(t, 3, chain.Height())
+	assert.Equal(t, big.NewInt(1), chain.blockWork(0x03000001))
+}
+
+func TestRetargetSpansInterval(t *testing.T) {
+	chain, genesis := powChain()
+	slot := int64(chain.Params().SlotDuration)
+
+	// Blocks exactly one slot apart keep the difficulty.
+	parent := genesis
+	for i := int64(1); i <= 3; i++ {
+		assert.Equal(t, uint32(easyBits), chain.NextBits())
+		b := minedBlockOn(parent, easyBits, genesis.Header.Timestamp+i*slot)
+		require.Nil(t, chain.AddBlock(b))
+		parent = b
+	}
+	assert.Equal(t, uint32(easyBits), chain.NextBits())
+}
+
+func TestRetargetAndForkChoiceByWork(t *testing.T) {
+	chain, genesis := powChain()
+	params := chain.Params()
+	params.RetargetInterval = 3
+	chain.SetParams(params)
+	slot := int64(params.SlotDuration)
+	// Mining starts long after the genesis block, which must not count as
+	// a slow first interval.
+	start := genesis.Header.Timestamp + 1000*slot
+
+	// A fast first interval makes the third block four times harder.
+	parent := genesis
+	for i := int64(1); i <= 2; i++ {
+		assert.Equal(t, uint32(easyBits), chain.NextBits())
+		b := minedBlockOn(parent, easyBits, start+i)
+		require.Nil(t, chain.AddBlock(b))
+		parent = b
+	}
+	harder := chain.NextBits()
+	expected := new(big.Int).Div(CompactToBig(easyBits), big.NewInt(4))
+	assert.Equal(t, BigToCompact(expected), harder)
+	a3 := minedBlockOn(parent, harder, start+3)
+	require.Nil(t, chain.AddBlock(a3))
+
+	// A slow branch keeps the initial difficulty, which is also the easiest.
+	parent = genesis
+	for i := int64(1); i <= 5; i++ {
+		b := minedBlockOn(parent, easyBits, start+i*100*slot)
+		require.Nil(t, chain.AddBlock(b))
+		parent = b
+	}
+
+	// The side branch is longer but has less work.
+	tip := chain.Tip()
+	assert.Equal(t, HashBlock(a3), HashHeader(tip))
+	assert.Equal(t, 3, chain.Height())
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"blocker/proto"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	pb "google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrInvalidEvidence = errors.New("invalid double signing evidence")
+	ErrSlashed         = errors.New("validator is already slashed")
+	ErrNoStake         = errors.New("offender has no stake and is no genesis validator")
+)
+
+// VerifyEvidence checks that e holds two different blocks at the same height
+// that both verify under the same key.
+func VerifyEvidence(e *proto.Evidence) error {
+	if e == nil || e.First == nil || e.Second == nil || e.First.Header == nil || e.Second.Header == nil {
+		return fmt.Errorf("%w: missing block", ErrInvalidEvidence)
+	}
+	for _, b := range []*proto.Block{e.First, e.Second} {
+		if verified, err := VerifyBlock(b); err != nil || !verified {
+			return fmt.Errorf("%w: block %s does not verify", ErrInvalidEvidence, hex.EncodeToString(HashBlock(b)))
+		}
+	}
+	if !bytes.Equal(e.First.PublicKey, e.Second.PublicKey) {
+		return fmt.Errorf("%w: blocks signed by different keys", ErrInvalidEvidence)
+	}
+	if e.First.Header.Height != e.Second.Header.Height {
+		return fmt.Errorf("%w: blocks at heights %d and %d", ErrInvalidEvidence, e.First.Header.Height, e.Second.Header.Height)
+	}
+	if bytes.Equal(HashBlock(e.First), HashBlock(e.Second)) {
+		return fmt.Errorf("%w: the blocks are the same", ErrInvalidEvidence)
+	}
+	return nil
+}
+
+// SlashTransaction builds the transaction that slashes the signer of e in
+// the block after the tip. It burns every output the offender has bonded or
+// still unbonding, and its inclusion removes the offender from the
+// validator set. A genesis validator without stake is slashed by a
+// transaction without inputs.
+func (c *Chain) SlashTransaction(e *proto.Evidence) (*proto.Transaction, error) {
+	if err := VerifyEvidence(e); err != nil {
+		return nil, err
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	state, err := c.stakeAt(c.tip())
+	if err != nil {
+		return nil, err
+	}
+	offender := hex.EncodeToString(e.First.PublicKey)
+	if state.slashed[offender] {
+		return nil, fmt.Errorf("%w: %s", ErrSlashed, offender)
+	}
+	height := c.height() + 1
+	bonds := []bond{}
+	for _, b := range state.bonds {
+		if b.validator == offender && (b.lockedUntil == 0 || height < b.lockedUntil) {
+			bonds = append(bonds, b)
+		}
+	}
+	if len(bonds) == 0 && !c.params.isGenesisValidator(e.First.PublicKey) {
+		return nil, fmt.Errorf("%w: %s", ErrNoStake, offender)
+	}
+	sort.Slice(bonds, func(i, j int) bool {
+		if c := bytes.Compare(bonds[i].hash, bonds[j].hash); c != 0 {
+			return c < 0
+		}
+		return bonds[i].index < bonds[j].index
+	})
+
+	// Only the signed parts of the blocks are needed as evidence.
+	evidence := &proto.Evidence{First: pb.Clone(e.First).(*proto.Block), Second: pb.Clone(e.Second).(*proto.Block)}
+	evidence.First.Transactions = nil
+	evidence.Second.Transactions = nil
+	tx := &proto.Transaction{
+		Version:  1,
+		Type:     proto.TxType_SLASH,
+		Evidence: evidence,
+	}
+	for _, b := range bonds {
+		tx.Inputs = append(tx.Inputs, &proto.TxInput{PrevTxHash: b.hash, PrevOutIndex: uint32(b.index)})
+	}
+	return tx, nil
+}
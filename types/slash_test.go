@@ -0,0 +1,92 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyEvidence(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	genesis := createGenesisBlock()
+	first, second := blockBy(genesis, key), blockBy(genesis, key)
+
+	assert.Nil(t, VerifyEvidence(&proto.Evidence{First: first, Second: second}))
+	assert.ErrorIs(t, VerifyEvidence(&proto.Evidence{First: first, Second: first}), ErrInvalidEvidence)
+	assert.ErrorIs(t, VerifyEvidence(&proto.Evidence{First: first, Second: blockBy(first, key)}), ErrInvalidEvidence)
+	assert.ErrorIs(t, VerifyEvidence(&proto.Evidence{First: first, Second: blockBy(genesis, crypto.GeneratePrivateKey())}), ErrInvalidEvidence)
+	assert.ErrorIs(t, VerifyEvidence(&proto.Evidence{First: first}), ErrInvalidEvidence)
+}
+
+func TestSlashDoubleSigning(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	chain, b1, stake := stakingChain(t, validator)
+
+	b2 := blockBy(b1, validator)
+	require.Nil(t, chain.AddBlock(b2))
+	set, err := chain.ValidatorSet(3)
+	require.Nil(t, err)
+	assert.True(t, set.IsValidator(validator.Public().Bytes()))
+
+	evidence := &proto.Evidence{First: b2, Second: blockBy(b1, validator)}
+	slash, err := chain.SlashTransaction(evidence)
+	require.Nil(t, err)
+	require.Len(t, slash.Inputs, 1)
+	assert.Equal(t, HashTransaction(stake), slash.Inputs[0].PrevTxHash)
+	assert.Nil(t, slash.Evidence.First.Transactions)
+
+	// The coinbase of the slashing block does not collect the burned stake.
+	require.Nil(t, chain.AddBlock(blockBy(b2, validator, slash)))
+	utxo, err := chain.GetUTXO(HashTransaction(stake), 0)
+	require.Nil(t, err)
+	assert.True(t, utxo.Spent)
+
+	set, err = chain.ValidatorSet(4)
+	require.Nil(t, err)
+	assert.Zero(t, set.Stake(validator.Public().Bytes()))
+	_, err = chain.SlashTransaction(evidence)
+	assert.ErrorIs(t, err, ErrSlashed)
+
+	b3, _ := chain.GetBlockByHeight(3)
+	restake := spendOutput(stake, 1, proto.TxType_STAKE, 400)
+	restake.Validator = validator.Public().Bytes()
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b3, validator, signTx(restake))), ErrSlashed)
+}
+
+func TestSlashGenesisValidator(t *testing.T) {
+	keys := []*crypto.PrivateKey{crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()}
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	params := chain.Params()
+	params.SlotDuration = time.Second
+	params.Validators = [][]byte{keys[0].Public().Bytes(), keys[1].Public().Bytes()}
+	chain.SetParams(params)
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	// The genesis validators take turns, keys[1] leads the odd slots.
+	b1 := blockBy(genesis, keys[1])
+	require.Nil(t, chain.AddBlock(b1))
+	slash, err := chain.SlashTransaction(&proto.Evidence{First: b1, Second: blockBy(genesis, keys[1])})
+	require.Nil(t, err)
+	assert.Empty(t, slash.Inputs)
+	b2 := blockBy(b1, keys[0], slash)
+	require.Nil(t, chain.AddBlock(b2))
+
+	set, err := chain.ValidatorSet(3)
+	require.Nil(t, err)
+	assert.False(t, set.IsValidator(keys[1].Public().Bytes()))
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b2, keys[1])), ErrWrongLeader)
+	require.Nil(t, chain.AddBlock(blockBy(b2, keys[0])))
+
+	// Evidence against a key that is no validator slashes nothing.
+	outsider := crypto.GeneratePrivateKey()
+	evidence := &proto.Evidence{First: blockBy(b2, outsider), Second: blockBy(b2, outsider)}
+	_, err = chain.SlashTransaction(evidence)
+	assert.ErrorIs(t, err, ErrNoStake)
+	b3, _ := chain.GetBlockByHeight(3)
+	forged := &proto.Transaction{Version: 1, Type: proto.TxType_SLASH, Evidence: evidence}
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b3, keys[0], forged)), ErrNoStake)
+}
@@ -0,0 +1,419 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	defaultEpochLength     = 10
+	defaultUnbondingPeriod = 20
+)
+
+var (
+	ErrInvalidStake = errors.New("invalid staking transaction")
+	ErrBondedOutput = errors.New("bonded output can only be spent by an unstake transaction")
+	ErrNotBonded    = errors.New("output is not bonded to the validator")
+	ErrLockedOutput = errors.New("output is still unbonding")
+)
+
+// Validator is a block producer and the stake bonded to it.
+type Validator struct {
+	PublicKey []byte
+	Stake     int64
+}
+
+// ValidatorSet holds the validators of an epoch, ordered by public key.
+type ValidatorSet struct {
+	Validators []Validator
+	total      int64
+	// seed makes leader selection weighted by stake; the genesis set has
+	// none and its validators take turns.
+	seed []byte
+	// open lets any key produce blocks, which is the case without stake
+	// and without genesis validators.
+	open bool
+}
+
+func newValidatorSet(stakes map[string]int64, seed []byte) *ValidatorSet {
+	set := &ValidatorSet{seed: seed}
+	for key, stake := range stakes {
+		pubKey, _ := hex.DecodeString(key)
+		set.Validators = append(set.Validators, Validator{PublicKey: pubKey, Stake: stake})
+		set.total += stake
+	}
+	sort.Slice(set.Validators, func(i, j int) bool {
+		return bytes.Compare(set.Validators[i].PublicKey, set.Validators[j].PublicKey) < 0
+	})
+	return set
+}
+
+// isGenesisValidator reports whether pubKey is one of the genesis validators.
+func (p Params) isGenesisValidator(pubKey []byte) bool {
+	for _, key := range p.Validators {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// genesisSet returns the genesis validators of p with one unit of stake each.
+func genesisSet(p Params) *ValidatorSet {
+	set := &ValidatorSet{open: len(p.Validators) == 0}
+	for _, key := range p.Validators {
+		set.Validators = append(set.Validators, Validator{PublicKey: key, Stake: 1})
+		set.total++
+	}
+	return set
+}
+
+// without returns the set without the validators in removed.
+func (s *ValidatorSet) without(removed map[string]bool) *ValidatorSet {
+	set := &ValidatorSet{seed: s.seed, open: s.open}
+	for _, v := range s.Validators {
+		if !removed[hex.EncodeToString(v.PublicKey)] {
+			set.Validators = append(set.Validators, v)
+			set.total += v.Stake
+		}
+	}
+	return set
+}
+
+func (s *ValidatorSet) Len() int {
+	return len(s.Validators)
+}
+
+// Open reports whether any key may produce blocks.
+func (s *ValidatorSet) Open() bool {
+	return s.open
+}
+
+func (s *ValidatorSet) TotalStake() int64 {
+	return s.total
+}
+
+// Stake returns the stake of pubKey, 0 if it is not in the set.
+func (s *ValidatorSet) Stake(pubKey []byte) int64 {
+	for _, v := range s.Validators {
+		if bytes.Equal(v.PublicKey, pubKey) {
+			return v.Stake
+		}
+	}
+	return 0
+}
+
+// IsValidator reports whether pubKey may produce blocks.
+func (s *ValidatorSet) IsValidator(pubKey []byte) bool {
+	return s.open || s.Stake(pubKey) > 0
+}
+
+// Leader returns the public key of the validator allowed to produce the
+// block in slot, or nil if there is none. Each validator leads a share of
+// the slots proportional to its stake.
+func (s *ValidatorSet) Leader(slot int64) []byte {
+	if s.Len() == 0 {
+		return nil
+	}
+	if s.seed == nil {
+		return s.Validators[slot%int64(s.Len())].PublicKey
+	}
+	buf := binary.BigEndian.AppendUint64(bytes.Clone(s.seed), uint64(slot))
+	h := sha256.Sum256(buf)
+	pick := int64(binary.BigEndian.Uint64(h[:8]) % uint64(s.total))
+	for _, v := range s.Validators {
+		if pick < v.Stake {
+			return v.PublicKey
+		}
+		pick -= v.Stake
+	}
+	return nil
+}
+
+// bond is an output bonded or unbonding as stake.
+type bond struct {
+	validator string
+	hash      []byte
+	index     int
+	amount    int64
+	// lockedUntil is set once the output is unbonding.
+	lockedUntil int
+}
+
+// stakeState is the stake on a branch after some block.
+type stakeState struct {
+	bonds   map[string]bond
+	slashed map[string]bool
+}
+
+func newStakeState() *stakeState {
+	return &stakeState{
+		bonds:   make(map[string]bond),
+		slashed: make(map[string]bool),
+	}
+}
+
+func (s *stakeState) clone() *stakeState {
+	c := newStakeState()
+	for key, b := range s.bonds {
+		c.bonds[key] = b
+	}
+	for key := range s.slashed {
+		c.slashed[key] = true
+	}
+	return c
+}
+
+// apply records the staking changes of b, the block at height.
+func (s *stakeState) apply(b *proto.Block, height int, p Params) {
+	for _, tx := range b.Transactions {
+		for _, input := range tx.Inputs {
+			delete(s.bonds, utxoKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex)))
+		}
+		hash := HashTransaction(tx)
+		validator := hex.EncodeToString(tx.Validator)
+		switch tx.Type {
+		case proto.TxType_STAKE:
+			s.bonds[utxoKey(hex.EncodeToString(hash), 0)] = bond{validator: validator, hash: hash, amount: tx.Outputs[0].Amount}
+		case proto.TxType_UNSTAKE:
+			for idx, output := range tx.Outputs {
+				s.bonds[utxoKey(hex.EncodeToString(hash), idx)] = bond{validator: validator, hash: hash, index: idx, amount: output.Amount, lockedUntil: height + p.UnbondingPeriod}
+			}
+		case proto.TxType_SLASH:
+			s.slashed[hex.EncodeToString(tx.Evidence.First.PublicKey)] = true
+		}
+	}
+}
+
+// CheckTxType checks that tx is a well formed transaction of its type. A
+// stake transaction bonds its first output to a validator key, an unstake
+// transaction unbonds outputs of a validator and a slash transaction burns
+// the outputs, if any, of the signer of valid evidence.
+func CheckTxType(tx *proto.Transaction) error {
+	switch tx.Type {
+	case proto.TxType_TRANSFER:
+		if len(tx.Validator) != 0 {
+			return fmt.Errorf("%w: transfer with a validator key", ErrInvalidStake)
+		}
+	case proto.TxType_STAKE:
+		if len(tx.Validator) != crypto.PubKeyLen {
+			return fmt.Errorf("%w: invalid validator key", ErrInvalidStake)
+		}
+		if len(tx.Outputs) == 0 || tx.Outputs[0].Amount <= 0 {
+			return fmt.Errorf("%w: nothing to bond", ErrInvalidStake)
+		}
+	case proto.TxType_UNSTAKE:
+		if len(tx.Validator) != crypto.PubKeyLen || len(tx.Inputs) == 0 {
+			return fmt.Errorf("%w: unstake needs a validator key and bonded inputs", ErrInvalidStake)
+		}
+	case proto.TxType_SLASH:
+		if err := VerifyEvidence(tx.Evidence); err != nil {
+			return err
+		}
+		if len(tx.Validator) != 0 || len(tx.Outputs) != 0 {
+			return fmt.Errorf("%w: slash with a validator key or outputs", ErrInvalidStake)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %d", ErrInvalidStake, tx.Type)
+	}
+	return nil
+}
+
+// OutputUTXO returns the UTXO the output at idx of tx, whose hash is given,
+// becomes once tx is included in the block at height.
+func (p Params) OutputUTXO(tx *proto.Transaction, hash string, idx int, height int) *UTXO {
+	utxo := &UTXO{
+		Hash:     hash,
+		OutIndex: idx,
+		Amount:   tx.Outputs[idx].Amount,
+		Owner:    tx.Outputs[idx].ToAddress,
+	}
+	switch {
+	case tx.Type == proto.TxType_STAKE && idx == 0:
+		utxo.Validator = tx.Validator
+	case tx.Type == proto.TxType_UNSTAKE:
+		utxo.Validator = tx.Validator
+		utxo.LockedUntil = height + p.UnbondingPeriod
+	}
+	return utxo
+}
+
+// CheckSpend checks that input of tx may spend utxo in the block at height.
+// Except in a slash transaction the input has to be signed by the owner of
+// utxo.
+func CheckSpend(tx *proto.Transaction, input *proto.TxInput, utxo *UTXO, height int) error {
+	key := utxoKey(utxo.Hash, utxo.OutIndex)
+	bonded := len(utxo.Validator) > 0 && utxo.LockedUntil == 0
+	if tx.Type == proto.TxType_SLASH {
+		unbonding := len(utxo.Validator) > 0 && height < utxo.LockedUntil
+		if !bytes.Equal(utxo.Validator, tx.Evidence.First.PublicKey) || !(bonded || unbonding) {
+			return fmt.Errorf("%w: %s is not staked by the offender", ErrNotBonded, key)
+		}
+		return nil
+	}
+	if len(input.PublicKey) != crypto.PubKeyLen || !bytes.Equal(crypto.PublicKeyFromBytes(input.PublicKey).Address().Bytes(), utxo.Owner) {
+		return fmt.Errorf("%w: %s", ErrNotOwner, key)
+	}
+	switch tx.Type {
+	case proto.TxType_UNSTAKE:
+		if !bonded || !bytes.Equal(utxo.Validator, tx.Validator) {
+			return fmt.Errorf("%w: %s", ErrNotBonded, key)
+		}
+	default:
+		if bonded {
+			return fmt.Errorf("%w: %s", ErrBondedOutput, key)
+		}
+	}
+	if height < utxo.LockedUntil {
+		return fmt.Errorf("%w: %s is locked until height %d", ErrLockedOutput, key, utxo.LockedUntil)
+	}
+	return nil
+}
+
+// checkStake checks that tx does not stake to a slashed validator and does
+// not slash one again. A slash without inputs has to remove a genesis
+// validator.
+func (v *BlockView) checkStake(tx *proto.Transaction) error {
+	switch tx.Type {
+	case proto.TxType_STAKE:
+		if validator := hex.EncodeToString(tx.Validator); v.stake.slashed[validator] {
+			return fmt.Errorf("%w: stake to %s", ErrSlashed, validator)
+		}
+	case proto.TxType_SLASH:
+		offender := hex.EncodeToString(tx.Evidence.First.PublicKey)
+		if v.stake.slashed[offender] || v.slashed[offender] {
+			return fmt.Errorf("%w: %s", ErrSlashed, offender)
+		}
+		if len(tx.Inputs) == 0 && !v.chain.params.isGenesisValidator(tx.Evidence.First.PublicKey) {
+			return fmt.Errorf("%w: %s", ErrNoStake, offender)
+		}
+	}
+	return nil
+}
+
+// ValidatorSet returns the validators of the block at height on the main
+// chain, which may be the block after the tip.
+func (c *Chain) ValidatorSet(height int) (*ValidatorSet, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if height < 1 || height > c.height()+1 {
+		return nil, fmt.Errorf("no validator set for height %d at height %d", height, c.height())
+	}
+	header, err := c.headers.GetByHeight(height - 1)
+	if err != nil {
+		return nil, err
+	}
+	return c.validatorSet(c.index[hex.EncodeToString(HashHeader(header))])
+}
+
+// validatorSet returns the validators of the block after parent, on
+// parent's branch. They are the validators of its epoch that were not
+// slashed since.
+func (c *Chain) validatorSet(parent *blockNode) (*ValidatorSet, error) {
+	height := parent.height + 1
+	start := height - height%c.params.EpochLength
+	var boundary *blockNode
+	if start > 0 {
+		boundary = ancestor(parent, start-1)
+	}
+	set, err := c.epochSet(boundary)
+	if err != nil || c.params.ProofOfWork {
+		return set, err
+	}
+	state, err := c.stakeAt(parent)
+	if err != nil {
+		return nil, err
+	}
+	return set.without(state.slashed), nil
+}
+
+// epochSet returns the validator set of the epoch following the block
+// boundary, made of the stake bonded up to and including boundary. Without
+// any stake the genesis validators are used. A nil boundary is the first
+// epoch.
+func (c *Chain) epochSet(boundary *blockNode) (*ValidatorSet, error) {
+	if c.params.ProofOfWork {
+		return &ValidatorSet{open: true}, nil
+	}
+	if boundary == nil {
+		return genesisSet(c.params), nil
+	}
+	state, err := c.stakeAt(boundary)
+	if err != nil {
+		return nil, err
+	}
+	stakes := make(map[string]int64)
+	for _, b := range state.bonds {
+		if b.lockedUntil == 0 && !state.slashed[b.validator] {
+			stakes[b.validator] += b.amount
+		}
+	}
+	if len(stakes) == 0 {
+		return genesisSet(c.params), nil
+	}
+	seed, _ := hex.DecodeString(boundary.hash)
+	return newValidatorSet(stakes, seed), nil
+}
+
+// stakeAt returns the stake after node on its branch. It starts from the
+// state at the previous epoch boundary, which is cached.
+func (c *Chain) stakeAt(node *blockNode) (*stakeState, error) {
+	length := c.params.EpochLength
+	isBoundary := node.height%length == length-1
+	if isBoundary {
+		c.bondLock.Lock()
+		cached, ok := c.bondCache[node.hash]
+		c.bondLock.Unlock()
+		if ok {
+			return cached, nil
+		}
+	}
+
+	state := newStakeState()
+	first := ancestor(node, (node.height+1)/length*length-1)
+	if first == node {
+		first = ancestor(node, node.height-length)
+	}
+	if first != nil && first.height > 0 {
+		prev, err := c.stakeAt(first)
+		if err != nil {
+			return nil, err
+		}
+		state = prev.clone()
+	}
+
+	nodes := []*blockNode{}
+	for n := node; n != first && n.parent != nil; n = n.parent {
+		nodes = append(nodes, n)
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		b, err := c.blockStore.Get(nodes[i].hash)
+		if err != nil {
+			return nil, err
+		}
+		state.apply(b, nodes[i].height, c.params)
+	}
+
+	if isBoundary {
+		c.bondLock.Lock()
+		c.bondCache[node.hash] = state
+		c.bondLock.Unlock()
+	}
+	return state, nil
+}
+
+// ancestor returns the block at height on node's branch, or nil if height
+// is negative.
+func ancestor(node *blockNode, height int) *blockNode {
+	for node != nil && node.height > height {
+		node = node.parent
+	}
+	return node
+}
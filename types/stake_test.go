@@ -0,0 +1,141 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spendOutput returns a transaction of the given type spending output index
+// of prev, which the genesis key owns, into one output per amount.
+func spendOutput(prev *proto.Transaction, index uint32, typ proto.TxType, amounts ...int64) *proto.Transaction {
+	privKey := Factory{}.CreateGenesisPrivateKey()
+	tx := &proto.Transaction{
+		Version: 1,
+		Type:    typ,
+		Inputs: []*proto.TxInput{
+			{
+				PrevTxHash:   HashTransaction(prev),
+				PrevOutIndex: index,
+				PublicKey:    privKey.Public().Bytes(),
+			},
+		},
+	}
+	for _, amount := range amounts {
+		tx.Outputs = append(tx.Outputs, &proto.TxOutput{Amount: amount, ToAddress: privKey.Public().Address().Bytes()})
+	}
+	return tx
+}
+
+// signTx signs every input of tx with the genesis key.
+func signTx(tx *proto.Transaction) *proto.Transaction {
+	for _, input := range tx.Inputs {
+		input.Signature = SignTransaction(Factory{}.CreateGenesisPrivateKey(), tx).Bytes()
+	}
+	return tx
+}
+
+// blockBy creates a block on top of parent signed by key, in the slot of its
+// height.
+func blockBy(parent *proto.Block, key *crypto.PrivateKey, txx ...*proto.Transaction) *proto.Block {
+	b := blockOn(parent, txx...)
+	b.Header.Timestamp = int64(b.Header.Height) * int64(time.Second)
+	SignBlock(key, b)
+	return b
+}
+
+// stakingChain returns a chain with epochs of two blocks whose first block
+// bonds 600 of the genesis output to validator, with the change in output 1.
+func stakingChain(t *testing.T, validator *crypto.PrivateKey) (*Chain, *proto.Block, *proto.Transaction) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	params := chain.Params()
+	params.SlotDuration = time.Second
+	params.EpochLength = 2
+	params.UnbondingPeriod = 3
+	chain.SetParams(params)
+	genesis, _ := chain.GetBlockByHeight(0)
+
+	stake := spendOutput(genesis.Transactions[0], 0, proto.TxType_STAKE, 600, 400)
+	assert.ErrorIs(t, CheckTxType(signTx(stake)), ErrInvalidStake)
+	stake.Validator = validator.Public().Bytes()
+	signTx(stake)
+	b1 := blockBy(genesis, crypto.GeneratePrivateKey(), stake)
+	require.Nil(t, chain.AddBlock(b1))
+	return chain, b1, stake
+}
+
+func TestStakingValidatorSet(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	chain, b1, stake := stakingChain(t, validator)
+
+	set, err := chain.ValidatorSet(1)
+	require.Nil(t, err)
+	assert.Equal(t, 0, set.Len())
+	set, err = chain.ValidatorSet(2)
+	require.Nil(t, err)
+	assert.Equal(t, []Validator{{PublicKey: validator.Public().Bytes(), Stake: 600}}, set.Validators)
+
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b1, crypto.GeneratePrivateKey())), ErrWrongLeader)
+	transfer := signTx(spendOutput(stake, 0, proto.TxType_TRANSFER, 600))
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b1, validator, transfer)), ErrBondedOutput)
+	notBonded := spendOutput(stake, 1, proto.TxType_UNSTAKE, 400)
+	notBonded.Validator = validator.Public().Bytes()
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b1, validator, signTx(notBonded))), ErrNotBonded)
+
+	unstake := spendOutput(stake, 0, proto.TxType_UNSTAKE, 600)
+	unstake.Validator = validator.Public().Bytes()
+	signTx(unstake)
+	b2 := blockBy(b1, validator, unstake)
+	require.Nil(t, chain.AddBlock(b2))
+	utxo, err := chain.GetUTXO(HashTransaction(unstake), 0)
+	require.Nil(t, err)
+	assert.Equal(t, 5, utxo.LockedUntil)
+
+	// The validator stays in the set until the epoch ends while its
+	// unstaked coins are locked for the unbonding period.
+	_, err = chain.ValidatorSet(4)
+	assert.NotNil(t, err)
+	spendUnbonding := signTx(spendOutput(unstake, 0, proto.TxType_TRANSFER, 600))
+	assert.ErrorIs(t, chain.AddBlock(blockBy(b2, validator, spendUnbonding)), ErrLockedOutput)
+	require.Nil(t, chain.AddBlock(blockBy(b2, validator)))
+
+	set, err = chain.ValidatorSet(4)
+	require.Nil(t, err)
+	assert.Equal(t, 0, set.Len())
+}
+
+func TestSetParamsDropsCachedStake(t *testing.T) {
+	chain, _, _ := stakingChain(t, crypto.GeneratePrivateKey())
+	_, err := chain.ValidatorSet(2)
+	require.Nil(t, err)
+	assert.NotEmpty(t, chain.bondCache)
+
+	params := chain.Params()
+	params.EpochLength = 4
+	chain.SetParams(params)
+	assert.Empty(t, chain.bondCache)
+	set, err := chain.ValidatorSet(2)
+	require.Nil(t, err)
+	assert.Equal(t, 0, set.Len())
+}
+
+func TestLeaderWeightedByStake(t *testing.T) {
+	big, small := crypto.GeneratePrivateKey().Public(), crypto.GeneratePrivateKey().Public()
+	stakes := map[string]int64{hex.EncodeToString(big.Bytes()): 3, hex.EncodeToString(small.Bytes()): 1}
+	set := newValidatorSet(stakes, Factory{}.CreateHash())
+	assert.Equal(t, int64(4), set.TotalStake())
+
+	led := 0
+	for slot := int64(0); slot < 4000; slot++ {
+		if bytes.Equal(set.Leader(slot), big.Bytes()) {
+			led++
+		}
+	}
+	assert.InDelta(t, 3000, led, 200)
+}
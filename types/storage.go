@@ -3,10 +3,13 @@ package types
 import (
 	"blocker/proto"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sync"
 )
 
+var ErrNoTip = errors.New("no tip stored")
+
 type TXStorer interface {
 	Put(transaction *proto.Transaction) error
 	Get(string) (*proto.Transaction, error)
@@ -45,6 +48,7 @@ func (s *MemoryTXStore) Get(hash string) (*proto.Transaction, error) {
 type UTXOStorer interface {
 	Put(tx *UTXO) error
 	Get(hash string) (*UTXO, error)
+	Delete(hash string) error
 }
 
 type MemoryUTXOStore struct {
@@ -62,12 +66,19 @@ func (s *MemoryUTXOStore) Put(utxo *UTXO) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	key := fmt.Sprintf("%s_%d", utxo.Hash, utxo.OutIndex)
-	s.txx[key] = utxo
+	s.txx[utxoKey(utxo.Hash, utxo.OutIndex)] = utxo
 
 	return nil
 }
 
+func (s *MemoryUTXOStore) Delete(hash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.txx, hash)
+	return nil
+}
+
 func (s *MemoryUTXOStore) Get(hash string) (*UTXO, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -82,16 +93,40 @@ func (s *MemoryUTXOStore) Get(hash string) (*UTXO, error) {
 type BlockStorer interface {
 	Put(*proto.Block) error
 	Get(string) (*proto.Block, error)
+	// PutTip records the hash of the last block of the main chain so the
+	// chain can be rebuilt on restart. GetTip returns ErrNoTip if none is set.
+	PutTip(hash string) error
+	GetTip() (string, error)
+	// PutFinalized records the hash of the last finalized block so finality
+	// survives a restart. GetFinalized returns an empty hash if none is set.
+	PutFinalized(hash string) error
+	GetFinalized() (string, error)
+	// PutUndo and GetUndo store the undo data of a block by block hash.
+	PutUndo(hash string, undo *BlockUndo) error
+	GetUndo(hash string) (*BlockUndo, error)
+}
+
+// BlockUndo records the UTXO changes a block made so they can be reverted
+// when the block is disconnected.
+type BlockUndo struct {
+	// Created holds the keys of the UTXOs the block created.
+	Created []string
+	// Spent holds the UTXOs the block spent, as they were before.
+	Spent []*UTXO
 }
 
 type MemoryBlockStore struct {
-	lock   sync.RWMutex
-	blocks map[string]*proto.Block
+	lock      sync.RWMutex
+	blocks    map[string]*proto.Block
+	undo      map[string]*BlockUndo
+	tip       string
+	finalized string
 }
 
 func NewMemoryBlockStore() *MemoryBlockStore {
 	return &MemoryBlockStore{
 		blocks: make(map[string]*proto.Block),
+		undo:   make(map[string]*BlockUndo),
 	}
 }
 
@@ -111,3 +146,151 @@ func (s *MemoryBlockStore) Get(hash string) (*proto.Block, error) {
 	}
 	return block, nil
 }
+
+func (s *MemoryBlockStore) PutTip(hash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tip = hash
+	return nil
+}
+
+func (s *MemoryBlockStore) GetTip() (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.tip == "" {
+		return "", ErrNoTip
+	}
+	return s.tip, nil
+}
+
+func (s *MemoryBlockStore) PutFinalized(hash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.finalized = hash
+	return nil
+}
+
+func (s *MemoryBlockStore) GetFinalized() (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.finalized, nil
+}
+
+func (s *MemoryBlockStore) PutUndo(hash string, undo *BlockUndo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.undo[hash] = undo
+	return nil
+}
+
+func (s *MemoryBlockStore) GetUndo(hash string) (*BlockUndo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	undo, ok := s.undo[hash]
+	if !ok {
+		return nil, fmt.Errorf("undo data for block [%s] not found", hash)
+	}
+	return undo, nil
+}
+
+type batchOp struct {
+	block  *proto.Block
+	undo   *BlockUndo
+	hash   string
+	tip    string
+	tx     *proto.Transaction
+	utxo   *UTXO
+	delete string
+}
+
+// Batch collects the writes that apply or revert a block so they can be
+// committed together. When the block, tx and UTXO stores share one FileStore
+// the batch is written as a single record and is all-or-nothing. Otherwise
+// the writes are applied in the order they were added.
+//
+// UTXO reads should go through GetUTXO so they see the pending writes.
+type Batch struct {
+	blockStore BlockStorer
+	txStore    TXStorer
+	uxtoStore  UTXOStorer
+	ops        []batchOp
+	utxos      map[string]*UTXO
+}
+
+func NewBatch(bs BlockStorer, ts TXStorer, us UTXOStorer) *Batch {
+	return &Batch{
+		blockStore: bs,
+		txStore:    ts,
+		uxtoStore:  us,
+		utxos:      make(map[string]*UTXO),
+	}
+}
+
+func (b *Batch) PutBlock(block *proto.Block) {
+	b.ops = append(b.ops, batchOp{block: block})
+}
+
+func (b *Batch) PutUndo(hash string, undo *BlockUndo) {
+	b.ops = append(b.ops, batchOp{undo: undo, hash: hash})
+}
+
+func (b *Batch) PutTip(hash string) {
+	b.ops = append(b.ops, batchOp{tip: hash})
+}
+
+func (b *Batch) PutTx(tx *proto.Transaction) {
+	b.ops = append(b.ops, batchOp{tx: tx})
+}
+
+func (b *Batch) PutUTXO(utxo *UTXO) {
+	b.utxos[utxoKey(utxo.Hash, utxo.OutIndex)] = utxo
+	b.ops = append(b.ops, batchOp{utxo: utxo})
+}
+
+func (b *Batch) DeleteUTXO(hash string) {
+	b.utxos[hash] = nil
+	b.ops = append(b.ops, batchOp{delete: hash})
+}
+
+// GetUTXO returns the UTXO as it will be after the batch is committed.
+func (b *Batch) GetUTXO(hash string) (*UTXO, error) {
+	if utxo, ok := b.utxos[hash]; ok {
+		if utxo == nil {
+			return nil, fmt.Errorf("could not find UXTO")
+		}
+		return utxo, nil
+	}
+	return b.uxtoStore.Get(hash)
+}
+
+func (b *Batch) Commit() error {
+	if db := sharedFileDB(b.blockStore, b.txStore, b.uxtoStore); db != nil {
+		ops, err := b.dbOps()
+		if err != nil {
+			return err
+		}
+		return db.write(ops)
+	}
+
+	for _, op := range b.ops {
+		var err error
+		switch {
+		case op.block != nil:
+			err = b.blockStore.Put(op.block)
+		case op.undo != nil:
+			err = b.blockStore.PutUndo(op.hash, op.undo)
+		case op.tip != "":
+			err = b.blockStore.PutTip(op.tip)
+		case op.tx != nil:
+			err = b.txStore.Put(op.tx)
+		case op.utxo != nil:
+			err = b.uxtoStore.Put(op.utxo)
+		case op.delete != "":
+			err = b.uxtoStore.Delete(op.delete)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,23 +17,59 @@ func HashTransaction(tx *proto.Transaction) []byte {
 	return hash[:]
 }
 
+// SignatureHash returns the hash the inputs of tx sign: the hash of tx with
+// the signatures of all inputs cleared, so that every input can be signed
+// independently of the others.
+func SignatureHash(tx *proto.Transaction) []byte {
+	unsigned := pb.Clone(tx).(*proto.Transaction)
+	for _, input := range unsigned.Inputs {
+		input.Signature = nil
+	}
+	return HashTransaction(unsigned)
+}
+
 func SignTransaction(pk *crypto.PrivateKey, tx *proto.Transaction) *crypto.Signature {
-	return pk.Sign(HashTransaction(tx))
+	return pk.Sign(SignatureHash(tx))
 }
 
+// VerifyTransaction checks the signatures of the inputs of tx. The inputs of
+// a slash transaction are not signed, its evidence authorizes them.
 func VerifyTransaction(tx *proto.Transaction) bool {
+	if tx.Type == proto.TxType_SLASH {
+		return VerifyEvidence(tx.Evidence) == nil
+	}
+	hash := SignatureHash(tx)
 	for _, input := range tx.Inputs {
-		if len(input.Signature) == 0 {
-			panic("transaction has no signature")
+		if len(input.Signature) != crypto.SigLen || len(input.PublicKey) != crypto.PubKeyLen {
+			return false
 		}
 		sig := crypto.SignatureFromBytes(input.Signature)
-		pubKey := crypto.PublicKeyFromBytes(input.PublicKey)
-		oldSig := input.Signature
-		input.Signature = nil
-		if !sig.Verify(pubKey, HashTransaction(tx)) {
+		if !sig.Verify(crypto.PublicKeyFromBytes(input.PublicKey), hash) {
 			return false
 		}
-		input.Signature = oldSig
 	}
 	return true
 }
+
+// IsCoinbase reports whether tx mints new coins. Coinbase transactions have
+// no inputs and are only valid as the first transaction of a block. A slash
+// transaction may have no inputs as well but is no coinbase.
+func IsCoinbase(tx *proto.Transaction) bool {
+	return len(tx.Inputs) == 0 && tx.Type != proto.TxType_SLASH
+}
+
+// NewCoinbaseTransaction creates the coinbase for the block at height that
+// pays amount to the given address.
+func NewCoinbaseTransaction(to []byte, height int32, amount int64) *proto.Transaction {
+	return &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{},
+		Outputs: []*proto.TxOutput{
+			{
+				Amount:    amount,
+				ToAddress: to,
+			},
+		},
+		Height: height,
+	}
+}
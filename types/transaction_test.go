@@ -38,3 +38,32 @@ func TestHashTransaction(t *testing.T) {
 	assert.Equal(t, 64, len(sig.Bytes()))
 	assert.True(t, VerifyTransaction(tx))
 }
+
+func TestVerifyTransactionWithTwoInputs(t *testing.T) {
+	keys := []*crypto.PrivateKey{crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()}
+	tx := &proto.Transaction{
+		Version: 1,
+		Outputs: []*proto.TxOutput{{Amount: 10, ToAddress: Factory{}.CreateAddress()}},
+	}
+	for _, key := range keys {
+		tx.Inputs = append(tx.Inputs, &proto.TxInput{
+			PrevTxHash: util.RandomHash(),
+			PublicKey:  key.Public().Bytes(),
+		})
+	}
+
+	// The inputs are signed one after the other.
+	for i, key := range keys {
+		tx.Inputs[i].Signature = SignTransaction(key, tx).Bytes()
+	}
+	assert.True(t, VerifyTransaction(tx))
+
+	tx.Inputs[0].Signature, tx.Inputs[1].Signature = tx.Inputs[1].Signature, tx.Inputs[0].Signature
+	assert.False(t, VerifyTransaction(tx))
+}
+
+func TestVerifyTransactionWithoutSignature(t *testing.T) {
+	tx := Factory{}.CreateTransaction()
+
+	assert.False(t, VerifyTransaction(tx))
+}
@@ -0,0 +1,185 @@
+package types
+
+import (
+	"blocker/proto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrBadSignature       = errors.New("invalid transaction signature")
+	ErrMissingInput       = errors.New("input spends an unknown output")
+	ErrSpentInput         = errors.New("input spends an already spent output")
+	ErrDoubleSpend        = errors.New("output spent twice in the block")
+	ErrInsufficientInputs = errors.New("outputs exceed inputs")
+	ErrNotOwner           = errors.New("input is not signed by the owner of the output")
+	ErrBadAmount          = errors.New("output amount must be positive")
+)
+
+// TxError reports the transaction that made a block invalid.
+type TxError struct {
+	// Index is the position of the transaction in the block.
+	Index int
+	Hash  string
+	Err   error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("transaction %d (%s): %s", e.Index, e.Hash, e.Err)
+}
+
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// CheckOutputs checks that every output of tx pays a positive amount and
+// that together they do not overflow.
+func CheckOutputs(tx *proto.Transaction) error {
+	var total int64
+	for i, output := range tx.Outputs {
+		if output.Amount <= 0 {
+			return fmt.Errorf("%w: output %d pays %d", ErrBadAmount, i, output.Amount)
+		}
+		if total > math.MaxInt64-output.Amount {
+			return fmt.Errorf("%w: outputs overflow", ErrBadAmount)
+		}
+		total += output.Amount
+	}
+	return nil
+}
+
+// BlockView is the UTXO set as a transaction of a block sees it: the outputs
+// on the chain before the block together with the outputs created and spent
+// by the transactions before it in the block. It is used both to validate
+// blocks and to assemble them.
+type BlockView struct {
+	chain   *Chain
+	height  int
+	stake   *stakeState
+	created map[string]*UTXO
+	spent   map[string]bool
+	slashed map[string]bool
+}
+
+// NewBlockView returns the view of an empty block after the tip.
+func (c *Chain) NewBlockView() (*BlockView, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.newBlockView(c.tip())
+}
+
+// newBlockView returns the view of the block after parent, which has to be
+// the tip.
+func (c *Chain) newBlockView(parent *blockNode) (*BlockView, error) {
+	stake, err := c.stakeAt(parent)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockView{
+		chain:   c,
+		height:  parent.height + 1,
+		stake:   stake,
+		created: make(map[string]*UTXO),
+		spent:   make(map[string]bool),
+		slashed: make(map[string]bool),
+	}, nil
+}
+
+// Height returns the height of the block the view belongs to.
+func (v *BlockView) Height() int {
+	return v.height
+}
+
+// Add checks tx against the view, applies it and returns the fee it pays.
+// Slash transactions burn their inputs and pay no fee. A transaction that
+// fails a check leaves the view unchanged.
+func (v *BlockView) Add(tx *proto.Transaction) (int64, error) {
+	if IsCoinbase(tx) {
+		return 0, fmt.Errorf("coinbase transaction is only valid as the first transaction of a block")
+	}
+	if err := CheckTxType(tx); err != nil {
+		return 0, err
+	}
+	if err := CheckOutputs(tx); err != nil {
+		return 0, err
+	}
+	if !VerifyTransaction(tx) {
+		return 0, ErrBadSignature
+	}
+	if err := v.checkStake(tx); err != nil {
+		return 0, err
+	}
+
+	var sumInputs int64
+	seen := make(map[string]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		key := utxoKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex))
+		if v.spent[key] {
+			return 0, fmt.Errorf("%w: %s", ErrDoubleSpend, key)
+		}
+		if seen[key] {
+			return 0, fmt.Errorf("%w: %s is spent twice by the transaction", ErrDoubleSpend, key)
+		}
+		seen[key] = true
+		utxo, ok := v.created[key]
+		if !ok {
+			var err error
+			if utxo, err = v.chain.uxtoStore.Get(key); err != nil {
+				return 0, fmt.Errorf("%w: %s", ErrMissingInput, key)
+			}
+			if utxo.Spent {
+				return 0, fmt.Errorf("%w: %s", ErrSpentInput, key)
+			}
+		}
+		if err := CheckSpend(tx, input, utxo, v.height); err != nil {
+			return 0, err
+		}
+		sumInputs += utxo.Amount
+	}
+	var sumOutputs int64
+	for _, output := range tx.Outputs {
+		sumOutputs += output.Amount
+	}
+	if sumOutputs > sumInputs {
+		return 0, fmt.Errorf("%w: inputs are %d and outputs are %d", ErrInsufficientInputs, sumInputs, sumOutputs)
+	}
+
+	for _, input := range tx.Inputs {
+		v.spent[utxoKey(hex.EncodeToString(input.PrevTxHash), int(input.PrevOutIndex))] = true
+	}
+	hash := hex.EncodeToString(HashTransaction(tx))
+	for idx := range tx.Outputs {
+		v.created[utxoKey(hash, idx)] = v.chain.params.OutputUTXO(tx, hash, idx, v.height)
+	}
+	if tx.Type == proto.TxType_SLASH {
+		v.slashed[hex.EncodeToString(tx.Evidence.First.PublicKey)] = true
+		return 0, nil
+	}
+	return sumInputs - sumOutputs, nil
+}
+
+// validateTransactions checks the transactions of b, the block after
+// parent, after its coinbase and returns the fees they pay. Each input has
+// to spend an existing unspent output, which may be created earlier in the
+// block, no output may be spent twice and no transaction may spend more
+// than its inputs. The first failing transaction is reported as a *TxError.
+func (c *Chain) validateTransactions(b *proto.Block, parent *blockNode) (int64, error) {
+	view, err := c.newBlockView(parent)
+	if err != nil {
+		return 0, err
+	}
+	var fees int64
+	for i, tx := range b.Transactions {
+		if i == 0 {
+			continue
+		}
+		fee, err := view.Add(tx)
+		if err != nil {
+			return 0, &TxError{Index: i, Hash: hex.EncodeToString(HashTransaction(tx)), Err: err}
+		}
+		fees += fee
+	}
+	return fees, nil
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"blocker/crypto"
+	"blocker/proto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pb "google.golang.org/protobuf/proto"
+)
+
+func TestValidateBlockTransactions(t *testing.T) {
+	chain := NewChain(NewMemoryBlockStore(), NewMemoryTXStore())
+	genesis, _ := chain.GetBlockByHeight(0)
+	key := crypto.GeneratePrivateKey()
+
+	first := signTx(spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 600, 400))
+	second := signTx(spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 1000))
+	err := chain.AddBlock(blockBy(genesis, key, first, second))
+	assert.ErrorIs(t, err, ErrDoubleSpend)
+	var txErr *TxError
+	require.ErrorAs(t, err, &txErr)
+	assert.Equal(t, 2, txErr.Index)
+
+	// Negative outputs would let the positive ones mint coins.
+	minting := signTx(spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 1000000, -999000))
+	assert.ErrorIs(t, chain.ValidateTransaction(minting), ErrBadAmount)
+	assert.ErrorIs(t, chain.AddBlock(blockBy(genesis, key, minting)), ErrBadAmount)
+	zero := signTx(spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 1000, 0))
+	assert.ErrorIs(t, chain.ValidateTransaction(zero), ErrBadAmount)
+
+	twice := spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 2000)
+	twice.Inputs = append(twice.Inputs, pb.Clone(twice.Inputs[0]).(*proto.TxInput))
+	signTx(twice)
+	assert.ErrorIs(t, chain.ValidateTransaction(twice), ErrDoubleSpend)
+
+	missing := signTx(spendOutput(first, 0, proto.TxType_TRANSFER, 600))
+	assert.ErrorIs(t, chain.AddBlock(blockBy(genesis, key, missing)), ErrMissingInput)
+
+	forged := spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 1000)
+	forged.Inputs[0].Signature = SignTransaction(key, forged).Bytes()
+	assert.ErrorIs(t, chain.AddBlock(blockBy(genesis, key, forged)), ErrBadSignature)
+	// A valid signature by a key that does not own the output.
+	stolen := spendOutput(genesis.Transactions[0], 0, proto.TxType_TRANSFER, 1000)
+	stolen.Inputs[0].PublicKey = key.Public().Bytes()
+	stolen.Inputs[0].Signature = SignTransaction(key, stolen).Bytes()
+	assert.ErrorIs(t, chain.ValidateTransaction(stolen), ErrNotOwner)
+	assert.ErrorIs(t, chain.AddBlock(blockBy(genesis, key, stolen)), ErrNotOwner)
+	assert.Equal(t, 0, chain.Height())
+
+	// Outputs created earlier in the block can be spent by later transactions.
+	require.Nil(t, chain.AddBlock(blockBy(genesis, key, first, missing)))
+	assert.Equal(t, 1, chain.Height())
+
+	both := spendOutput(first, 1, proto.TxType_TRANSFER, 1000)
+	both.Inputs = append(both.Inputs, &proto.TxInput{
+		PrevTxHash: HashTransaction(missing),
+		PublicKey:  both.Inputs[0].PublicKey,
+	})
+	assert.Nil(t, chain.ValidateTransaction(signTx(both)))
+	utxo, err := chain.GetUTXO(HashTransaction(missing), 0)
+	require.Nil(t, err)
+	assert.Equal(t, Factory{}.CreateGenesisPrivateKey().Public().Address().Bytes(), utxo.Owner)
+}